@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+var usageWebhook = flag.String("usage-webhook", "", "post a usage record of every run to this url when it ends: api calls, bytes transferred and wall time, for charging back heavy runs")
+
+// apiCalls and apiBytes count the requests to the api server and the bytes of their responses.
+var apiCalls, apiBytes int64
+
+func validateUsageWebhook(cfgErr *ConfigError) {
+	if *usageWebhook == "" {
+		return
+	}
+	if u, err := url.Parse(*usageWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		cfgErr.Add("usage-webhook", "must be an http(s) url")
+	}
+}
+
+// ApplyUsageAccounting counts the api requests of config for -usage-webhook. The exec
+// streams count once, for the request upgrading them, their output is counted as streamed.
+func ApplyUsageAccounting(config *rest.Config) {
+	if *usageWebhook == "" {
+		return
+	}
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &accountingTransport{rt: rt}
+	})
+}
+
+type accountingTransport struct {
+	rt http.RoundTripper
+}
+
+func (t *accountingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&apiCalls, 1)
+	resp, err := t.rt.RoundTrip(req)
+	if err == nil && resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body = &accountingBody{ReadCloser: resp.Body}
+	}
+	return resp, err
+}
+
+type accountingBody struct {
+	io.ReadCloser
+}
+
+func (b *accountingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&apiBytes, int64(n))
+	return n, err
+}
+
+// UsageRecord is what the run cost: the api calls and their response bytes, the output
+// streamed from the pods and the wall time of the runner.
+func UsageRecord(resp *Response) map[string]interface{} {
+	progress.mu.Lock()
+	target, namespace, pod := progress.target, progress.namespace, progress.pod
+	progress.mu.Unlock()
+	if resp.Target != "" {
+		target = resp.Target
+	}
+	if resp.Pod != "" {
+		pod = resp.Pod
+	}
+	hostname, _ := os.Hostname()
+	stdout, stderr := atomic.LoadInt64(&progressStdout), atomic.LoadInt64(&progressStderr)
+	responseBytes := atomic.LoadInt64(&apiBytes)
+	record := map[string]interface{}{
+		"target":           target,
+		"namespace":        namespace,
+		"pod":              pod,
+		"runner":           hostname,
+		"status":           resp.Status(),
+		"started":          progress.start.UTC().Format(time.RFC3339),
+		"wallSeconds":      time.Since(progress.start).Seconds(),
+		"apiCalls":         atomic.LoadInt64(&apiCalls),
+		"apiResponseBytes": responseBytes,
+		"stdoutBytes":      stdout,
+		"stderrBytes":      stderr,
+		"bytesTransferred": responseBytes + stdout + stderr,
+	}
+	if labels := RunLabels(); labels != nil {
+		record["labels"] = labels
+	}
+	return record
+}
+
+// PostUsage posts the usage record of the run to -usage-webhook, failures are only reported.
+func PostUsage(resp *Response) {
+	if *usageWebhook == "" {
+		return
+	}
+	if err := PostWebhook(*usageWebhook, UsageRecord(resp)); err != nil {
+		fmt.Fprintf(os.Stderr, "usage webhook error: %v\n", err)
+	}
+}
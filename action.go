@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	ActionExec           = "exec"
+	ActionLogs           = "logs"
+	ActionRestart        = "restart"
+	ActionRolloutRestart = "rollout-restart"
+	ActionScale          = "scale"
+	ActionTCPCheck       = "tcp-check"
+	ActionFetch          = "fetch"
+)
+
+var (
+	action   = flag.String("action", ActionExec, "what is done: exec runs the command in the selected pod, logs collects its container log like kubectl logs, restart deletes it, rollout-restart restarts -workload like kubectl rollout restart, scale sets the -replicas of -workload, tcp-check connects to -port of the selected pod, fetch copies -path out of it")
+	minReady = flag.Int("min-ready", 1, "-action restart only deletes the pod while at least this many other ready pods match the selector")
+)
+
+// validateAction checks -action and that only exec takes a command.
+func validateAction(cfgErr *ConfigError, cmd []string) {
+	switch *action {
+	case ActionExec:
+		if len(cmd) == 0 {
+			cfgErr.Add("", "command is empty")
+		}
+		return
+	case ActionLogs:
+		validateLogs(cfgErr)
+	case ActionRestart:
+		if *minReady < 0 {
+			cfgErr.Add("min-ready", "must not be negative")
+		}
+		if *verifyCmd != "" {
+			cfgErr.Add("verify-cmd", "conflicts with -action restart, the pod is deleted")
+		}
+		if *sampleUsage != 0 {
+			cfgErr.Add("sample-usage", "conflicts with -action restart, the pod is deleted")
+		}
+		if *annotatePod {
+			cfgErr.Add("annotate-pod", "conflicts with -action restart, the pod is deleted")
+		}
+	case ActionFetch:
+		if policy.ForbidCopyFrom {
+			cfgErr.Add("action", "fetch copies files out of pods, forbidden by policy %s", policyFile)
+		}
+	case ActionRolloutRestart, ActionScale, ActionTCPCheck:
+	default:
+		cfgErr.Add("action", "must be exec, logs, restart, rollout-restart, scale, tcp-check or fetch")
+		return
+	}
+	if err := policy.CheckAction(*action); err != nil {
+		cfgErr.Add("action", "%v", err)
+	}
+	if len(cmd) > 0 {
+		cfgErr.Add("action", "%s takes no command", *action)
+	}
+	if *shell != "" {
+		cfgErr.Add("shell", "requires -action exec")
+	}
+	if *workdir != "" {
+		cfgErr.Add("workdir", "requires -action exec")
+	}
+	if *nsenterPID != 0 {
+		cfgErr.Add("nsenter-pid", "requires -action exec")
+	}
+}
+
+// runPodAction does -action in the picked pod, exec and logs report their output, tcp-check
+// the response.
+func runPodAction(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, target *Target, podName string, containerName string, uid types.UID, stdin io.Reader) (*ExecOutput, error) {
+	switch *action {
+	case ActionRestart:
+		return RestartPod(ctx, clientset, target, podName, uid)
+	case ActionTCPCheck:
+		return TCPCheck(ctx, clientset, config, target.Namespace, podName)
+	case ActionFetch:
+		return Fetch(ctx, clientset, config, target.Namespace, podName, containerName)
+	case ActionExec:
+		return ExecReattaching(ctx, clientset, config, target.Namespace, podName, containerName, target.Command, stdin)
+	}
+	return ExecWithOutputs(ctx, clientset, config, target.Namespace, podName, containerName, target.Command, stdin)
+}
+
+// RestartPod deletes the pod so its controller replaces it, unless fewer than -min-ready other
+// pods matching the target are ready. With -chaos-safe only the pod with the picked uid is deleted.
+func RestartPod(ctx context.Context, clientset *kubernetes.Clientset, target *Target, podName string, uid types.UID) (*ExecOutput, error) {
+	out := &ExecOutput{}
+	if *minReady > 0 {
+		ready := 0
+		if target.PodName == "" {
+			pods, err := ListCandidatePods(ctx, clientset, target.Namespace, target.Labels, target.FieldSelector(), "")
+			if err != nil {
+				return out, fmt.Errorf("list pods error: %v", err)
+			}
+			for i := range pods {
+				if pods[i].Name != podName && pods[i].DeletionTimestamp == nil && podReady(&pods[i]) {
+					ready++
+				}
+			}
+		}
+		if ready < *minReady {
+			return out, fmt.Errorf("refusing to delete pod %s, %d other pods are ready and -min-ready is %d", podName, ready, *minReady)
+		}
+	}
+	opts := v1.DeleteOptions{}
+	if uid != "" {
+		opts.Preconditions = &v1.Preconditions{UID: &uid}
+	}
+	if err := clientset.CoreV1().Pods(target.Namespace).Delete(ctx, podName, opts); err != nil {
+		return out, fmt.Errorf("delete pod error: %v", err)
+	}
+	out.Stdout = fmt.Sprintf("pod %s deleted", podName)
+	return out, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// alertmanagerSource is the rate limited source of events posted to /alertmanager.
+const alertmanagerSource = "alertmanager"
+
+// Remediation maps firing alerts of alertmanager to a run of a target of the config file.
+// podName and the command of the target may use {{label "<name>"}} and
+// {{annotation "<name>"}} of the alert, its namespace is fixed.
+type Remediation struct {
+	Alertname string `json:"alertname"`
+	// Labels the alert must have besides alertname.
+	Labels  map[string]string `json:"labels,omitempty"`
+	Target  string            `json:"target"`
+	Command string            `json:"command,omitempty"`
+	// Cooldown skips alerts remediating the same pod and command again within it, e.g. 30m.
+	Cooldown string `json:"cooldown,omitempty"`
+	// MaxPerHour bounds the runs of the remediation in any hour, 0 for unlimited.
+	MaxPerHour int `json:"maxPerHour,omitempty"`
+
+	cooldown time.Duration
+}
+
+// AlertData is available to the templates of a remediation target.
+type AlertData struct {
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// alertPlaceholderFuncs keep {{label "x"}} and {{annotation "x"}} while the config file is
+// rendered, they are filled for every alert.
+var alertPlaceholderFuncs = template.FuncMap{
+	"label": func(name string) string {
+		return fmt.Sprintf("{{label %q}}", name)
+	},
+	"annotation": func(name string) string {
+		return fmt.Sprintf("{{annotation %q}}", name)
+	},
+}
+
+type alertmanagerPayload struct {
+	Alerts []struct {
+		Status      string            `json:"status"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"alerts"`
+}
+
+// remediationLimits remembers the runs of every remediation for cooldowns and MaxPerHour.
+type remediationLimits struct {
+	mu      sync.Mutex
+	last    map[string]time.Time
+	perHour map[int][]time.Time
+}
+
+func validateRemediations(cfgErr *ConfigError, cfg *Config) {
+	for i := range cfg.Remediations {
+		rem := &cfg.Remediations[i]
+		field := fmt.Sprintf("f: remediations[%d]", i)
+		if rem.Alertname == "" {
+			cfgErr.Add(field, "alertname is required")
+		}
+		if rem.Cooldown != "" {
+			var err error
+			if rem.cooldown, err = time.ParseDuration(rem.Cooldown); err != nil || rem.cooldown < 0 {
+				cfgErr.Add(field, "cooldown must be a duration like 30m")
+			}
+		}
+		if rem.MaxPerHour < 0 {
+			cfgErr.Add(field, "maxPerHour must not be negative")
+		}
+		target := cfg.Target(rem.Target)
+		if target == nil {
+			cfgErr.Add(field, "unknown target %q", rem.Target)
+			continue
+		}
+		if rem.Command != "" {
+			if _, ok := target.Commands[rem.Command]; !ok {
+				cfgErr.Add(field, "target %q has no command %q", rem.Target, rem.Command)
+			}
+		} else if len(target.Command) == 0 {
+			cfgErr.Add(field, "target %q has only named commands, pick one with command", rem.Target)
+		}
+	}
+}
+
+// Matches reports whether the alert has the alertname and labels of the remediation.
+func (rem *Remediation) Matches(labels map[string]string) bool {
+	if labels["alertname"] != rem.Alertname {
+		return false
+	}
+	for k, v := range rem.Labels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// serveAlertmanager takes the webhook payload of alertmanager and runs the remediations of
+// its firing alerts, unless in cooldown or over MaxPerHour.
+func (r *webhookReceiver) serveAlertmanager(w http.ResponseWriter, req *http.Request) {
+	body, ok := r.readEvent(w, req, alertmanagerSource)
+	if !ok {
+		return
+	}
+	var payload alertmanagerPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, "not an alertmanager payload", http.StatusBadRequest)
+		return
+	}
+	runs, suppressed := []string{}, []string{}
+	for _, alert := range payload.Alerts {
+		if alert.Status != "firing" {
+			continue
+		}
+		for i := range r.cfg.Remediations {
+			rem := &r.cfg.Remediations[i]
+			if !rem.Matches(alert.Labels) {
+				continue
+			}
+			cfg := r.copyConfig()
+			target := cfg.Target(rem.Target)
+			if rem.Command != "" {
+				target.Command = target.Commands[rem.Command]
+			}
+			err := renderAlertTarget(target, &AlertData{
+				Labels:      alert.Labels,
+				Annotations: alert.Annotations,
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "remediation %s error: %v\n", rem.Alertname, err)
+				suppressed = append(suppressed, fmt.Sprintf("%s: %v", rem.Target, err))
+				continue
+			}
+			key := fmt.Sprintf("%d\x00%s\x00%s", i, target.PodName, strings.Join(target.Command, "\x00"))
+			if reason := r.limits.take(i, key, rem); reason != "" {
+				suppressed = append(suppressed, fmt.Sprintf("%s: %s", rem.Target, reason))
+				continue
+			}
+			r.run(cfg, rem.Target)
+			runs = append(runs, rem.Target)
+		}
+	}
+	sort.Strings(suppressed)
+	writeRuns(w, map[string]interface{}{
+		"runs":       runs,
+		"suppressed": suppressed,
+	}, len(runs) > 0)
+}
+
+// renderAlertTarget fills the alert into podName and the command of the target.
+func renderAlertTarget(target *Target, data *AlertData) error {
+	podName, err := renderAlertTemplate(target.PodName, data)
+	if err != nil {
+		return fmt.Errorf("render podName error: %v", err)
+	}
+	target.PodName = podName
+	cmd := make([]string, len(target.Command))
+	for i, arg := range target.Command {
+		if cmd[i], err = renderAlertTemplate(arg, data); err != nil {
+			return fmt.Errorf("render command error: %v", err)
+		}
+	}
+	target.Command = cmd
+	return nil
+}
+
+func renderAlertTemplate(s string, data *AlertData) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	lookup := func(kind string, values map[string]string) func(string) (string, error) {
+		return func(name string) (string, error) {
+			v, ok := values[name]
+			if !ok {
+				return "", fmt.Errorf("the alert has no %s %q", kind, name)
+			}
+			return v, nil
+		}
+	}
+	tmpl, err := template.New("alert").Funcs(template.FuncMap{
+		"label":      lookup("label", data.Labels),
+		"annotation": lookup("annotation", data.Annotations),
+	}).Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// take records a run of the remediation, or returns why it is suppressed.
+func (l *remediationLimits) take(i int, key string, rem *Remediation) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if last, ok := l.last[key]; ok && rem.cooldown > 0 && now.Sub(last) < rem.cooldown {
+		return fmt.Sprintf("cooldown until %s", DisplayTime(last.Add(rem.cooldown)))
+	}
+	var recent []time.Time
+	for _, t := range l.perHour[i] {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	if rem.MaxPerHour > 0 && len(recent) >= rem.MaxPerHour {
+		l.perHour[i] = recent
+		return fmt.Sprintf("maxPerHour %d reached", rem.MaxPerHour)
+	}
+	l.last[key] = now
+	l.perHour[i] = append(recent, now)
+	return ""
+}
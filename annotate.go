@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	AnnotationLastRun      = "cronjob.puper.io/last-run"
+	AnnotationLastStatus   = "cronjob.puper.io/last-status"
+	AnnotationLastDuration = "cronjob.puper.io/last-duration"
+	AnnotationTarget       = "cronjob.puper.io/target"
+	AnnotationTargetPod    = "cronjob.puper.io/target-pod"
+	AnnotationExitCode     = "cronjob.puper.io/exit-code"
+	AnnotationError        = "cronjob.puper.io/error"
+)
+
+// maxAnnotatedError keeps the error annotation readable in kubectl describe.
+const maxAnnotatedError = 256
+
+var (
+	annotatePod = flag.Bool("annotate-pod", false, "annotate the target pod with the last run time, status and duration")
+	annotateJob = flag.Bool("annotate-job", false, "annotate the job running k8s-cronjob, e.g. created by a CronJob, with the target pod, status, exit code and duration of the run")
+
+	// runStart is when the run began, the duration annotated on the job.
+	runStart = time.Now()
+)
+
+// AnnotatePod records the outcome of a run started at start on the target pod.
+func AnnotatePod(clientset *kubernetes.Clientset, namespace string, podName string, start time.Time, status string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				AnnotationLastRun:      start.UTC().Format(time.RFC3339),
+				AnnotationLastStatus:   status,
+				AnnotationLastDuration: time.Since(start).Round(time.Millisecond).String(),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	return RetryAPI(ctx, func() error {
+		_, err := clientset.CoreV1().Pods(namespace).Patch(ctx, podName, types.MergePatchType, patch, v1.PatchOptions{})
+		return err
+	})
+}
+
+// AnnotateRunnerJob records the outcome of the run on the job owning the runner pod, so
+// kubectl describe job shows it. Nothing is done outside of a job.
+func AnnotateRunnerJob(clientset *kubernetes.Clientset, resp *Response) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	job, err := RunnerJob(ctx, clientset)
+	if err != nil || job == nil {
+		return err
+	}
+	annotations := map[string]interface{}{
+		AnnotationLastRun:      runStart.UTC().Format(time.RFC3339),
+		AnnotationLastStatus:   resp.Status(),
+		AnnotationLastDuration: time.Since(runStart).Round(time.Millisecond).String(),
+		AnnotationTarget:       nil,
+		AnnotationTargetPod:    nil,
+		AnnotationExitCode:     nil,
+		AnnotationError:        nil,
+	}
+	if resp.Target != "" {
+		annotations[AnnotationTarget] = resp.Target
+	}
+	if resp.Pod != "" {
+		annotations[AnnotationTargetPod] = resp.Pod
+	}
+	if code, ok := ExitCode(resp.Error); ok {
+		annotations[AnnotationExitCode] = strconv.Itoa(code)
+	}
+	if resp.Error != nil {
+		msg := resp.Error.Error()
+		if len(msg) > maxAnnotatedError {
+			msg = msg[:maxAnnotatedError-3] + "..."
+		}
+		annotations[AnnotationError] = msg
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return RetryAPI(ctx, func() error {
+		_, err := clientset.BatchV1().Jobs(job.Namespace).Patch(ctx, job.Name, types.MergePatchType, patch, v1.PatchOptions{})
+		return err
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	apiRetryBaseDelay = time.Millisecond * 500
+	apiRetryMaxDelay  = time.Second * 30
+)
+
+var (
+	apiMaxRetries = flag.Int("api-retries", 5, "max retries of a throttled (429) or failed (5xx) kubernetes api call")
+
+	// apiRetryCount is the number of api retries done so far by this process.
+	apiRetryCount int32
+)
+
+// RetryAPI calls fn until it succeeds or fails with an error that is not worth retrying.
+// The wait between attempts doubles up to apiRetryMaxDelay, a Retry-After sent by the
+// api server takes precedence. Waiting stops when ctx is done.
+func RetryAPI(ctx context.Context, fn func() error) error {
+	backoff := apiRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= *apiMaxRetries || !IsRetryableAPIError(err) {
+			return err
+		}
+		delay := backoff
+		if seconds, ok := apierrors.SuggestsClientDelay(err); ok && seconds > 0 {
+			delay = time.Duration(seconds) * time.Second
+		}
+		if delay > apiRetryMaxDelay {
+			delay = apiRetryMaxDelay
+		}
+		atomic.AddInt32(&apiRetryCount, 1)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		backoff *= 2
+		if backoff > apiRetryMaxDelay {
+			backoff = apiRetryMaxDelay
+		}
+	}
+}
+
+// IsRetryableAPIError reports whether err is a 429 or 5xx status returned by the api server.
+func IsRetryableAPIError(err error) bool {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return false
+	}
+	code := status.Status().Code
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+func APIRetries() int {
+	return int(atomic.LoadInt32(&apiRetryCount))
+}
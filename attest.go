@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	inTotoPayloadType  = "application/vnd.in-toto+json"
+	inTotoStatementV1  = "https://in-toto.io/Statement/v1"
+	slsaProvenanceV1   = "https://slsa.dev/provenance/v1"
+	attestationBuildV1 = "https://github.com/puper/k8s-cronjob/exec/v1"
+)
+
+var (
+	attestKey = flag.String("attest-key", "", "PEM private key, ECDSA or ed25519, signing an in-toto attestation of every run, e.g. from cosign generate-key-pair decrypted with openssl")
+	attestOut = flag.String("attest-out", "", "file the signed attestations are appended to, one DSSE envelope per line, or an http(s) url they are posted to")
+
+	attestOnce   sync.Once
+	attestSigner crypto.Signer
+	attestErr    error
+)
+
+func validateAttestation(cfgErr *ConfigError) {
+	if *attestKey == "" {
+		if *attestOut != "" {
+			cfgErr.Add("attest-out", "requires -attest-key")
+		}
+		return
+	}
+	if *attestOut == "" {
+		cfgErr.Add("attest-key", "requires -attest-out")
+	}
+	if _, err := attestationSigner(); err != nil {
+		cfgErr.Add("attest-key", "%v", err)
+	}
+}
+
+// attestationSigner loads -attest-key once.
+func attestationSigner() (crypto.Signer, error) {
+	attestOnce.Do(func() {
+		attestSigner, attestErr = loadSigner(*attestKey)
+	})
+	return attestSigner, attestErr
+}
+
+func loadSigner(path string) (crypto.Signer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in %s", path)
+	}
+	if block.Type == "EC PRIVATE KEY" {
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if strings.Contains(block.Type, "ENCRYPTED") {
+		return nil, fmt.Errorf("%s is encrypted, decrypt it to PKCS#8 first", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s is no ECDSA or ed25519 key", path)
+}
+
+// InTotoStatement is an in-toto statement with a SLSA provenance predicate: the subjects are
+// the digests of stdout and stderr, the build the command and where it ran.
+type InTotoStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []ResourceDigest    `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     ProvenancePredicate `json:"predicate"`
+}
+
+type ResourceDigest struct {
+	Name        string                 `json:"name"`
+	Digest      map[string]string      `json:"digest,omitempty"`
+	Annotations map[string]interface{} `json:"annotations,omitempty"`
+}
+
+type ProvenancePredicate struct {
+	BuildDefinition struct {
+		BuildType          string                 `json:"buildType"`
+		ExternalParameters map[string]interface{} `json:"externalParameters"`
+		InternalParameters map[string]interface{} `json:"internalParameters,omitempty"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID      string            `json:"id"`
+			Version map[string]string `json:"version,omitempty"`
+		} `json:"builder"`
+		Metadata struct {
+			InvocationID string `json:"invocationId"`
+			StartedOn    string `json:"startedOn"`
+			FinishedOn   string `json:"finishedOn"`
+		} `json:"metadata"`
+		Byproducts []ResourceDigest `json:"byproducts"`
+	} `json:"runDetails"`
+}
+
+// Envelope is a DSSE envelope, verifiable with cosign verify-blob-attestation.
+type Envelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     string              `json:"payload"`
+	Signatures  []EnvelopeSignature `json:"signatures"`
+}
+
+type EnvelopeSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// Attest signs an attestation of the run and writes it to -attest-out when -attest-key is set.
+func Attest(target *Target, start time.Time, resp *Response) error {
+	if *attestKey == "" {
+		return nil
+	}
+	signer, err := attestationSigner()
+	if err != nil {
+		return err
+	}
+	runID, err := newRunID()
+	if err != nil {
+		return err
+	}
+	statement := NewRunStatement(target, start, runID, resp)
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+	sig, err := signDSSE(signer, inTotoPayloadType, payload)
+	if err != nil {
+		return fmt.Errorf("sign attestation error: %v", err)
+	}
+	envelope := &Envelope{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []EnvelopeSignature{{
+			Sig: base64.StdEncoding.EncodeToString(sig),
+		}},
+	}
+	if strings.HasPrefix(*attestOut, "http://") || strings.HasPrefix(*attestOut, "https://") {
+		return PostWebhook(*attestOut, envelope)
+	}
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(*attestOut, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// NewRunStatement describes what command ran where with which result. The command is
+// redacted like the output.
+func NewRunStatement(target *Target, start time.Time, runID string, resp *Response) *InTotoStatement {
+	command := RedactArgs(target.Command)
+	s := &InTotoStatement{
+		Type: inTotoStatementV1,
+		Subject: []ResourceDigest{
+			{Name: "stdout", Digest: sha256Digest(resp.Stdout)},
+			{Name: "stderr", Digest: sha256Digest(resp.Stderr)},
+		},
+		PredicateType: slsaProvenanceV1,
+	}
+	p := &s.Predicate
+	p.BuildDefinition.BuildType = attestationBuildV1
+	p.BuildDefinition.ExternalParameters = map[string]interface{}{
+		"target":    target.Name,
+		"namespace": target.Namespace,
+		"pod":       resp.Pod,
+		"container": target.Container,
+		"command":   command,
+	}
+	if *action != ActionExec {
+		p.BuildDefinition.InternalParameters = map[string]interface{}{
+			"action": *action,
+		}
+	}
+	p.RunDetails.Builder.ID = "k8s-cronjob"
+	if runner != nil {
+		p.RunDetails.Builder.ID = fmt.Sprintf("k8s-cronjob://%s/%s", runner.Namespace, runner.Pod)
+	} else if hostname, err := os.Hostname(); err == nil {
+		p.RunDetails.Builder.ID = "k8s-cronjob://" + hostname
+	}
+	p.RunDetails.Builder.Version = map[string]string{
+		"k8s-cronjob": version,
+	}
+	p.RunDetails.Metadata.InvocationID = runID
+	p.RunDetails.Metadata.StartedOn = start.UTC().Format(time.RFC3339)
+	p.RunDetails.Metadata.FinishedOn = time.Now().UTC().Format(time.RFC3339)
+	result := map[string]interface{}{
+		"status": resp.Status(),
+	}
+	if code, ok := ExitCode(resp.Error); ok {
+		result["exitCode"] = code
+	}
+	p.RunDetails.Byproducts = []ResourceDigest{{
+		Name:        "result",
+		Annotations: result,
+	}}
+	return s
+}
+
+func sha256Digest(s string) map[string]string {
+	sum := sha256.Sum256([]byte(s))
+	return map[string]string{
+		"sha256": hex.EncodeToString(sum[:]),
+	}
+}
+
+// signDSSE signs the DSSE pre-authentication encoding of the payload.
+func signDSSE(signer crypto.Signer, payloadType string, payload []byte) ([]byte, error) {
+	pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload)
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		return signer.Sign(rand.Reader, []byte(pae), crypto.Hash(0))
+	}
+	digest := sha256.Sum256([]byte(pae))
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// PodStatusTimedOutPartial is the status of a command that timed out after it wrote output.
+const PodStatusTimedOutPartial = "timed-out-with-partial-output"
+
+// cancelDrainTimeout is how long output the command sent before it was cancelled is still
+// taken from the stream.
+const cancelDrainTimeout = time.Second
+
+var (
+	execTimeout   = flag.Duration("exec-timeout", 0, "cancel the command after this long, 0 for no timeout")
+	remoteKillCmd = flag.String("remote-kill-cmd", "", "shell command run in the pod when the command is cancelled, {{cmd}} is replaced by the quoted command, e.g. pkill -TERM -f {{cmd}}")
+)
+
+// ExecContext is cancelled by SIGTERM, SIGINT, -exec-timeout or the learned timeout, capped by the policy, or
+// -deadline-margin before the runner deadline.
+func ExecContext(clientset *kubernetes.Clientset, learned time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	var deadline time.Time
+	timeout, learned := RunTimeout(learned)
+	learnedTimeout = learned
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if *deadlineMargin > 0 {
+		if runnerDeadline, ok := RunnerDeadline(clientset); ok {
+			runnerDeadline = runnerDeadline.Add(-*deadlineMargin)
+			if deadline.IsZero() || runnerDeadline.Before(deadline) {
+				deadline = runnerDeadline
+			}
+		}
+	}
+	if deadline.IsZero() {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// RunTimeout returns the timeout of a run, -exec-timeout capped by the policy or the learned
+// timeout when it is shorter, and the learned timeout when it applies, else 0.
+func RunTimeout(learned time.Duration) (time.Duration, time.Duration) {
+	timeout := policy.ExecTimeout()
+	if learned > 0 && (timeout <= 0 || learned < timeout) {
+		return learned, learned
+	}
+	return timeout, 0
+}
+
+// KillRemote runs -remote-kill-cmd in the pod so a cancelled command does not keep running there.
+func KillRemote(clientset *kubernetes.Clientset, config *rest.Config, namespace string, podName string, containerName string, cmd []string) {
+	if *remoteKillCmd == "" {
+		return
+	}
+	killCmd := strings.ReplaceAll(*remoteKillCmd, "{{cmd}}", shellQuote(strings.Join(cmd, " ")))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	killArgs, _ := ShellArgs(DefaultShell(), killCmd)
+	if _, stderr, err := ExecInPod(ctx, clientset, config, namespace, podName, containerName, killArgs); err != nil {
+		fmt.Fprintf(os.Stderr, "remote kill error: %v %s\n", err, stderr)
+	}
+}
+
+func shellQuote(s string) string {
+	if *targetOS == OSWindows {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
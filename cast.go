@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var recordCast = flag.String("record-cast", "", "record the timing and output of the command to this asciinema v2 file for replay, {{pod}} is replaced by the pod name")
+
+// castRecorder writes output events of an asciinema v2 recording, stdout and stderr share it.
+type castRecorder struct {
+	mu    sync.Mutex
+	f     *os.File
+	start time.Time
+}
+
+// newCastRecorder creates the recording of cmd running in the pod.
+func newCastRecorder(path string, podName string, cmd []string) (*castRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create cast file error: %v", err)
+	}
+	r := &castRecorder{f: f, start: time.Now()}
+	header, _ := json.Marshal(map[string]interface{}{
+		"version":   2,
+		"width":     80,
+		"height":    24,
+		"timestamp": r.start.Unix(),
+		"command":   Redact(strings.Join(cmd, " ")),
+		"title":     podName,
+	})
+	if _, err := fmt.Fprintf(f, "%s\n", header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("write cast file error: %v", err)
+	}
+	return r, nil
+}
+
+// record appends an output event of redacted data, without a tty nothing turns \n into
+// \r\n for the player.
+func (r *castRecorder) record(data string) {
+	if data == "" {
+		return
+	}
+	data = strings.ReplaceAll(strings.ReplaceAll(data, "\r\n", "\n"), "\n", "\r\n")
+	line, _ := json.Marshal([]interface{}{time.Since(r.start).Seconds(), "o", data})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(r.f, "%s\n", line)
+}
+
+func (r *castRecorder) Close() error {
+	return r.f.Close()
+}
+
+// castWriter records the writes a line at a time before passing them on, Flush records the
+// last line.
+type castWriter struct {
+	w      io.Writer
+	r      *castRecorder
+	redact lineRedactor
+}
+
+func (c *castWriter) Write(p []byte) (int, error) {
+	c.r.record(c.redact.lines(p))
+	return c.w.Write(p)
+}
+
+// Flush records what followed the last newline.
+func (c *castWriter) Flush() {
+	c.r.record(c.redact.flush())
+}
+
+// Reset passes a restart of the stream on to the wrapped writer, the recording keeps the
+// dropped attempt.
+func (c *castWriter) Reset() error {
+	c.Flush()
+	return resetWriter(c.w)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	runTarget  = flag.String("run", "", "name of the -f target to start with, default the first target")
+	runCommand = flag.String("command", "", "name of the commands entry of the -run target to run instead of its command")
+)
+
+// ChainData is available to the command templates of a target started by onSuccess,
+// e.g. {{.Stdout}} is the stdout of the previous target.
+type ChainData struct {
+	Namespace string
+	Pod       string
+	Stdout    string
+}
+
+// RunConfig runs a target of the -f config file and the targets following it through onSuccess.
+func RunConfig(cmd []string) {
+	cfgErr := &ConfigError{}
+	if len(cmd) > 0 {
+		cfgErr.Add("f", "the command comes from the config file, do not pass one")
+		SendError(&Response{
+			Error: cfgErr,
+		})
+	}
+	cfg, err := LoadConfig(*configFile)
+	if err != nil {
+		cfgErr.Add("f", "%v", err)
+		SendError(&Response{
+			Error: cfgErr,
+		})
+	}
+	runConfig(cfg)
+}
+
+// runConfig validates cfg and runs the -run target and the targets following it.
+func runConfig(cfg *Config) {
+	cfgErr := &ConfigError{}
+	if err := ValidateConfig(cfg); err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	first := cfg.Targets[0].Name
+	if *runTarget != "" {
+		if cfg.Target(*runTarget) == nil {
+			cfgErr.Add("run", "unknown target %q", *runTarget)
+			SendError(&Response{
+				Error: cfgErr,
+			})
+		}
+		first = *runTarget
+	}
+	if *runCommand != "" {
+		target := cfg.Target(first)
+		cmd, ok := target.Commands[*runCommand]
+		if !ok {
+			cfgErr.Add("command", "target %q has no command %q", first, *runCommand)
+			SendError(&Response{
+				Error: cfgErr,
+			})
+		}
+		target.Command = cmd
+	}
+	if len(cfg.Target(first).Command) == 0 {
+		cfgErr.Add("command", "target %q has only named commands, pick one", first)
+		SendError(&Response{
+			Error: cfgErr,
+		})
+	}
+	CompilePodSelection()
+	config, clientset, err := NewClient()
+	if err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	ctx, cancel := ExecContext(clientset, chainLearnedTimeout(clientset, cfg, first))
+	defer cancel()
+	GrafanaStart("target " + first)
+	resp := RunChain(ctx, clientset, config, cfg, first)
+	finish(clientset, resp)
+}
+
+// chainLearnedTimeout returns the learned timeout of the named target. A chain shares one
+// context, only a single target has a history to learn from.
+func chainLearnedTimeout(clientset *kubernetes.Clientset, cfg *Config, name string) time.Duration {
+	target := cfg.Target(name)
+	if target.OnSuccess != "" {
+		return 0
+	}
+	return LearnedTimeout(clientset, target.Namespace, target.HistoryConfigMap, target.Command)
+}
+
+// RunChain runs the named target, then while runs succeed the target named by onSuccess.
+// The returned response is the last step, with every step in Steps. -cooldown and
+// -pause-from apply to the chain as a whole, through its first target.
+func RunChain(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, cfg *Config, name string) *Response {
+	first := cfg.Target(name)
+	if resp := SkipRun(ctx, clientset, first); resp != nil {
+		resp.Steps = []*Response{resp}
+		return resp
+	}
+	var steps []*Response
+	var prev *Response
+	var prevTarget *Target
+	for name != "" {
+		target := *cfg.Target(name)
+		step := runChainStep(ctx, clientset, config, &target, prevTarget, prev)
+		steps = append(steps, step)
+		if step.Error != nil || step.SkipReason != "" {
+			break
+		}
+		prev, prevTarget = step, &target
+		name = target.OnSuccess
+	}
+	last := *steps[len(steps)-1]
+	last.Steps = steps
+	if last.Error != nil {
+		ReleaseCooldown(clientset, first)
+	}
+	return &last
+}
+
+func runChainStep(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, target *Target, prevTarget *Target, prev *Response) *Response {
+	var stdin io.Reader
+	if prev != nil {
+		cmd, err := renderCommand(target.Command, &ChainData{
+			Namespace: prevTarget.Namespace,
+			Pod:       prev.Pod,
+			Stdout:    prev.Stdout,
+		})
+		if err != nil {
+			return &Response{
+				Target: target.Name,
+				Error:  fmt.Errorf("render command error: %v", err),
+			}
+		}
+		target.Command = cmd
+		if target.StdinFromPrevious {
+			stdin = strings.NewReader(prev.Stdout)
+		}
+	}
+	if target.ServiceAccount != "" {
+		var err error
+		if config, clientset, err = ImpersonateClient(config, target.Namespace, target.ServiceAccount); err != nil {
+			return &Response{
+				Target: target.Name,
+				Error:  err,
+			}
+		}
+	}
+	resp := SelectAndRun(ctx, clientset, config, target, stdin)
+	resp.Target = target.Name
+	return resp
+}
+
+// ImpersonateClient returns a copy of config and a client acting as the service account.
+func ImpersonateClient(config *rest.Config, namespace string, name string) (*rest.Config, *kubernetes.Clientset, error) {
+	config = rest.CopyConfig(config)
+	config.Impersonate = rest.ImpersonationConfig{
+		UserName: fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name),
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create impersonating client error: %v", err)
+	}
+	return config, clientset, nil
+}
+
+func renderCommand(cmd []string, data *ChainData) ([]string, error) {
+	rendered := make([]string, len(cmd))
+	for i, arg := range cmd {
+		tmpl, err := template.New("command").Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		rendered[i] = buf.String()
+	}
+	return rendered, nil
+}
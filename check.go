@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	expectPods   = flag.Int("expect-pods", -1, "check: exact number of eligible ready pods, -1 for any")
+	expectLeader = flag.String("expect-leader", "", "check: expression at least one eligible pod must match, e.g. metadata.labels.role==leader")
+	expectImage  = flag.String("expect-image", "", "pattern the image must match, e.g. mysql:8.*, without / also matched without the registry: the container the command runs in, with check the target container (-cn, else every container) of each eligible pod")
+)
+
+// CheckResult is the outcome of the check subcommand.
+type CheckResult struct {
+	Matched  int      `json:"matched"`
+	Eligible int      `json:"eligible"`
+	Ready    int      `json:"ready"`
+	Leader   string   `json:"leader,omitempty"`
+	Failures []string `json:"failures,omitempty"`
+}
+
+// RunCheck verifies the expectations about the target pods without executing anything.
+func RunCheck() {
+	cfgErr := validateTargetFlags()
+	var leader *PodExpr
+	if *expectLeader != "" {
+		var err error
+		if leader, err = ParsePodExpr(*expectLeader); err != nil {
+			cfgErr.Add("expect-leader", "malformed expression: %v", err)
+		}
+	}
+	if *expectImage != "" {
+		validateExpectImage(cfgErr, "expect-image", *expectImage)
+	}
+	if *expectPods < -1 {
+		cfgErr.Add("expect-pods", "must not be less than -1")
+	}
+	if err := cfgErr.ErrOrNil(); err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	selector, _ := BuildLabelSelector()
+	CompilePodSelection()
+	_, clientset, err := NewClient()
+	if err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	pods, err := ListCandidatePods(context.Background(), clientset, *namespace, selector, BuildFieldSelector(*podIP, *nodeName), *podName)
+	if err != nil {
+		SendError(&Response{
+			Error: fmt.Errorf("list pods error: %v", err),
+		})
+	}
+	result := CheckPods(pods, leader)
+	resp := &Response{
+		Check: result,
+	}
+	if len(result.Failures) > 0 {
+		resp.Error = fmt.Errorf("check failed: %d of the expectations not met", len(result.Failures))
+		SendError(resp)
+	}
+	SendSuccess(resp)
+}
+
+// CheckPods evaluates the -expect-* flags against the pods.
+func CheckPods(pods []corev1.Pod, leader *PodExpr) *CheckResult {
+	result := &CheckResult{
+		Matched: len(pods),
+	}
+	for i := range pods {
+		pod := &pods[i]
+		if !IsEligiblePod(pod) {
+			continue
+		}
+		result.Eligible++
+		if podReady(pod) {
+			result.Ready++
+		}
+		if leader != nil && result.Leader == "" {
+			if ok, err := leader.Match(pod); err != nil {
+				result.Failures = append(result.Failures, fmt.Sprintf("%s: %v", pod.Name, err))
+			} else if ok {
+				result.Leader = pod.Name
+			}
+		}
+		if *expectImage != "" {
+			for _, c := range pod.Spec.Containers {
+				if *containerName != "" && c.Name != *containerName {
+					continue
+				}
+				if !ImageMatches(*expectImage, c.Image) {
+					result.Failures = append(result.Failures, fmt.Sprintf("%s: container %s image %s does not match %s", pod.Name, c.Name, c.Image, *expectImage))
+				}
+			}
+		}
+	}
+	if *expectPods >= 0 && result.Ready != *expectPods {
+		result.Failures = append(result.Failures, fmt.Sprintf("%d eligible ready pods, expected %d", result.Ready, *expectPods))
+	}
+	if leader != nil && result.Leader == "" {
+		result.Failures = append(result.Failures, fmt.Sprintf("no eligible pod matches %s", leader))
+	}
+	return result
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
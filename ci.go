@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	CIResultsArgo   = "argo"
+	CIResultsTekton = "tekton"
+)
+
+// tekton keeps at most 4096 bytes of results per step, stdout and stderr are cut to fit.
+const (
+	tektonMaxStdout = 2048
+	tektonMaxStderr = 1024
+)
+
+var (
+	ciResults    = flag.String("ci-results", "", "argo or tekton, also write the status, exit code, pod, duration, stdout and stderr as files the pipeline reads as step results")
+	ciResultsDir = flag.String("ci-results-dir", "", "directory of the -ci-results files, default /tmp/outputs for argo and /tekton/results for tekton")
+)
+
+func validateCIResults(cfgErr *ConfigError) {
+	if *ciResults != "" && *ciResults != CIResultsArgo && *ciResults != CIResultsTekton {
+		cfgErr.Add("ci-results", "must be argo or tekton")
+	}
+	if *ciResultsDir != "" && *ciResults == "" {
+		cfgErr.Add("ci-results-dir", "requires -ci-results")
+	}
+}
+
+// WriteCIResults writes one file per result field for argo output parameters
+// (valueFrom.path) or tekton results ($(results.<name>.path)).
+func WriteCIResults(resp *Response) {
+	if *ciResults != CIResultsArgo && *ciResults != CIResultsTekton {
+		return
+	}
+	dir := *ciResultsDir
+	if dir == "" {
+		dir = "/tmp/outputs"
+		if *ciResults == CIResultsTekton {
+			dir = "/tekton/results"
+		}
+	}
+	exitCode := 0
+	if resp.Error != nil {
+		exitCode = 1
+		if code, ok := ExitCode(resp.Error); ok {
+			exitCode = code
+		}
+	}
+	stdout, stderr := resp.Stdout, resp.Stderr
+	if *ciResults == CIResultsTekton {
+		stdout, stderr = cutUTF8(stdout, tektonMaxStdout), cutUTF8(stderr, tektonMaxStderr)
+	}
+	results := map[string]string{
+		"status":    resp.Status(),
+		"exit-code": strconv.Itoa(exitCode),
+		"pod":       resp.Pod,
+		"duration":  resp.Duration,
+		"stdout":    stdout,
+		"stderr":    stderr,
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "write ci results error: %v\n", err)
+		return
+	}
+	for name, value := range results {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "write ci results error: %v\n", err)
+		}
+	}
+}
+
+// cutUTF8 cuts s to at most n bytes without splitting a utf-8 sequence.
+func cutUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// maxDiffBytes bounds the diff of -expect-identical-stdout in the report.
+const maxDiffBytes = 8192
+
+var expectIdenticalStdout = flag.Bool("expect-identical-stdout", false, "with -all, fail when the stdout of the succeeded pods differs, e.g. config checksums across replicas, the divergent pods and a diff against the most common stdout are reported")
+
+func validateCompare(cfgErr *ConfigError) {
+	if !*expectIdenticalStdout {
+		return
+	}
+	if !*allPods {
+		cfgErr.Add("expect-identical-stdout", "requires -all")
+	}
+	if *stdoutFile != "" {
+		cfgErr.Add("expect-identical-stdout", "conflicts with -stdout-file, the stdout is not kept")
+	}
+}
+
+// normalizeStdout drops trailing spaces of lines and blank lines around the output.
+func normalizeStdout(s string) string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// compareStdout sets Divergent to the succeeded pods whose stdout differs from the most common
+// one, and Diff to a line diff of every other stdout against it.
+func (r *BatchReport) compareStdout() {
+	var outputs []string
+	pods := map[string][]string{}
+	for _, result := range r.Pods {
+		if result.Status != PodStatusSucceeded {
+			continue
+		}
+		out := normalizeStdout(result.Stdout)
+		if _, ok := pods[out]; !ok {
+			outputs = append(outputs, out)
+		}
+		pods[out] = append(pods[out], result.Pod)
+	}
+	if len(outputs) < 2 {
+		return
+	}
+	reference := outputs[0]
+	for _, out := range outputs[1:] {
+		if len(pods[out]) > len(pods[reference]) {
+			reference = out
+		}
+	}
+	var diff strings.Builder
+	for _, out := range outputs {
+		if out == reference {
+			continue
+		}
+		r.Divergent = append(r.Divergent, pods[out]...)
+		fmt.Fprintf(&diff, "--- %s\n+++ %s\n", podList(pods[reference]), podList(pods[out]))
+		diff.WriteString(lineDiff(strings.Split(reference, "\n"), strings.Split(out, "\n")))
+	}
+	r.Diff = cutUTF8(diff.String(), maxDiffBytes)
+}
+
+func podList(pods []string) string {
+	if len(pods) > 3 {
+		return fmt.Sprintf("%s and %d more", strings.Join(pods[:3], ", "), len(pods)-3)
+	}
+	return strings.Join(pods, ", ")
+}
+
+// lineDiff lists the lines only in a with -, those only in b with +, in order of a longest
+// common subsequence. Long outputs are compared as a whole.
+func lineDiff(a []string, b []string) string {
+	var out strings.Builder
+	if len(a)*len(b) > 1000000 {
+		for _, line := range a {
+			out.WriteString("-" + line + "\n")
+		}
+		for _, line := range b {
+			out.WriteString("+" + line + "\n")
+		}
+		return out.String()
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("-" + a[i] + "\n")
+			i++
+		default:
+			out.WriteString("+" + b[j] + "\n")
+			j++
+		}
+	}
+	return out.String()
+}
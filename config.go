@@ -0,0 +1,195 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+var configFile = flag.String("f", "", "config file of targets")
+
+// Config is the content of the -f config file.
+type Config struct {
+	// ServiceAccount the runner uses, for generated rbac.
+	ServiceAccount          string   `json:"serviceAccount,omitempty"`
+	ServiceAccountNamespace string   `json:"serviceAccountNamespace,omitempty"`
+	Targets                 []Target `json:"targets"`
+	// Triggers map events posted to -listen-webhook to targets.
+	Triggers []Trigger `json:"triggers,omitempty"`
+	// Remediations map alerts posted by alertmanager to -listen-webhook to targets.
+	Remediations []Remediation `json:"remediations,omitempty"`
+}
+
+// Target describes one command and the pod it runs in, fields mirror the flags.
+type Target struct {
+	Name      string   `json:"name,omitempty"`
+	Namespace string   `json:"namespace,omitempty"`
+	PodName   string   `json:"podName,omitempty"`
+	Labels    string   `json:"labels,omitempty"`
+	PodIP     string   `json:"podIP,omitempty"`
+	NodeName  string   `json:"nodeName,omitempty"`
+	Container string   `json:"container,omitempty"`
+	Command   []string `json:"command,omitempty"`
+	// Commands are named commands, -command picks one instead of Command.
+	Commands         map[string][]string `json:"commands,omitempty"`
+	HistoryConfigMap string              `json:"historyConfigMap,omitempty"`
+	AnnotatePod      bool                `json:"annotatePod,omitempty"`
+	// ServiceAccount in Namespace the runner impersonates for this target, so the runner
+	// itself does not need pods/exec there.
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	// OnSuccess names the target run next when this one succeeded.
+	OnSuccess string `json:"onSuccess,omitempty"`
+	// StdinFromPrevious feeds the stdout of the previous target in the chain to the command.
+	StdinFromPrevious bool `json:"stdinFromPrevious,omitempty"`
+	// ExpectImage is a pattern the image of the container must match for the command to run.
+	ExpectImage string `json:"expectImage,omitempty"`
+	// PostConditions must hold after the command succeeded for the run to succeed.
+	PostConditions []PostCondition `json:"postConditions,omitempty"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if err := loadTemplate(path, cfg); err != nil {
+		return nil, err
+	}
+	for i := range cfg.Targets {
+		if cfg.Targets[i].Namespace == "" {
+			cfg.Targets[i].Namespace = "default"
+		}
+	}
+	return cfg, nil
+}
+
+// loadTemplate renders the file at path with the values and parses it into obj.
+func loadTemplate(path string, obj interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values, err := LoadValues()
+	if err != nil {
+		return err
+	}
+	if b, err = renderConfig(b, values); err != nil {
+		return fmt.Errorf("render config %s error: %v", path, err)
+	}
+	if err := yaml.UnmarshalStrict(b, obj); err != nil {
+		return fmt.Errorf("parse config %s error: %v", path, err)
+	}
+	return nil
+}
+
+// Target returns the target with the given name, nil if there is none.
+func (c *Config) Target(name string) *Target {
+	for i := range c.Targets {
+		if c.Targets[i].Name == name {
+			return &c.Targets[i]
+		}
+	}
+	return nil
+}
+
+// ValidateConfig checks every target like the flags are checked, and that onSuccess
+// chains end.
+func ValidateConfig(cfg *Config) error {
+	cfgErr := &ConfigError{}
+	if len(cfg.Targets) == 0 {
+		cfgErr.Add("f", "no targets")
+	}
+	names := map[string]bool{}
+	for i, t := range cfg.Targets {
+		field := fmt.Sprintf("f: targets[%d]", i)
+		if t.Name != "" {
+			if names[t.Name] {
+				cfgErr.Add(field, "duplicate name %q", t.Name)
+			}
+			names[t.Name] = true
+		}
+		validateTarget(cfgErr, field, &t)
+		if next := cfg.Target(t.OnSuccess); t.OnSuccess != "" && next == nil {
+			cfgErr.Add(field, "onSuccess names unknown target %q", t.OnSuccess)
+		} else if next != nil && len(next.Command) == 0 {
+			cfgErr.Add(field, "onSuccess target %q has no command", t.OnSuccess)
+		}
+	}
+	validateTriggers(cfgErr, cfg)
+	validateRemediations(cfgErr, cfg)
+	validateCooldown(cfgErr)
+	validatePauseFrom(cfgErr)
+	validateUsageWebhook(cfgErr)
+	validateReattachTimeout(cfgErr)
+	validateAttestation(cfgErr)
+	validateEndWebhookOutput(cfgErr)
+	validateDisplayTimezone(cfgErr)
+	validateLearnedTimeout(cfgErr)
+	validateSinks(cfgErr)
+	validatePodSelection(cfgErr)
+	for _, t := range cfg.Targets {
+		seen := map[string]bool{}
+		for next := &t; next != nil && next.OnSuccess != ""; next = cfg.Target(next.OnSuccess) {
+			if seen[next.Name] {
+				cfgErr.Add("f", "onSuccess chain starting at %q loops", t.Name)
+				break
+			}
+			seen[next.Name] = true
+		}
+	}
+	return cfgErr.ErrOrNil()
+}
+
+// FieldSelector returns the pod field selector of PodIP and NodeName.
+func (t *Target) FieldSelector() string {
+	return BuildFieldSelector(t.PodIP, t.NodeName)
+}
+
+// validateTarget checks the pod selection and command of a target.
+func validateTarget(cfgErr *ConfigError, field string, t *Target) {
+	if (t.PodName == "") == (t.Labels == "" && t.PodIP == "" && t.NodeName == "") {
+		cfgErr.Add(field, "exactly one of podName or labels, podIP and nodeName is required")
+	}
+	if t.Labels != "" {
+		if _, err := k8slabels.Parse(t.Labels); err != nil {
+			cfgErr.Add(field, "malformed label selector: %v", err)
+		}
+	}
+	if err := CheckNamespacePolicy(t.Namespace); err != nil {
+		cfgErr.Add(field, "%v", err)
+	}
+	if t.StdinFromPrevious {
+		if err := policy.CheckStdin(); err != nil {
+			cfgErr.Add(field, "stdinFromPrevious: %v", err)
+		}
+	}
+	if len(t.Command) == 0 && len(t.Commands) == 0 {
+		cfgErr.Add(field, "command is empty")
+	}
+	for name, cmd := range t.Commands {
+		if len(cmd) == 0 {
+			cfgErr.Add(field, "command %q is empty", name)
+		}
+	}
+	if t.ExpectImage != "" {
+		validateExpectImage(cfgErr, field, t.ExpectImage)
+	}
+	validatePostConditions(cfgErr, field, t.PostConditions)
+}
+
+// FlagsTarget returns the target described by the command line flags.
+func FlagsTarget(cmd []string) Target {
+	selector, _ := BuildLabelSelector()
+	return Target{
+		Namespace:        *namespace,
+		PodName:          *podName,
+		Labels:           selector,
+		PodIP:            *podIP,
+		NodeName:         *nodeName,
+		Container:        *containerName,
+		Command:          cmd,
+		HistoryConfigMap: *historyConfigMap,
+		AnnotatePod:      *annotatePod,
+		ExpectImage:      *expectImage,
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var validateOffline = flag.Bool("validate-offline", false, "validate: only check the config file and flags, not the access of the runner in the cluster and the webhook urls")
+
+// RunValidate checks the -f config file and the flags it is run with without running
+// anything, and reports every problem found at once.
+func RunValidate() {
+	cfgErr := &ConfigError{}
+	if *configFile == "" {
+		cfgErr.Add("f", "is required")
+	}
+	if len(flag.Args()) > 0 {
+		cfgErr.Add("f", "the command comes from the config file, do not pass one")
+	}
+	validateRunMode(cfgErr)
+	if *configFile == "" {
+		SendError(&Response{
+			Error: cfgErr,
+		})
+	}
+	cfg, err := LoadConfig(*configFile)
+	if err != nil {
+		cfgErr.Add("f", "%v", err)
+		SendError(&Response{
+			Error: cfgErr,
+		})
+	}
+	var problems *ConfigError
+	if err := ValidateConfig(cfg); errors.As(err, &problems) {
+		cfgErr.Problems = append(cfgErr.Problems, problems.Problems...)
+	}
+	if *listenWebhook != "" {
+		if *hmacSecret == "" {
+			cfgErr.Add("hmac-secret", "is required with -listen-webhook")
+		}
+		if len(cfg.Triggers) == 0 && len(cfg.Remediations) == 0 {
+			cfgErr.Add("f", "no triggers or remediations")
+		}
+	}
+	if !*validateOffline {
+		checkWebhookURLs(cfgErr)
+		if _, clientset, err := NewClient(); err != nil {
+			cfgErr.Add("", "%v", err)
+		} else {
+			checkRBAC(context.Background(), clientset, cfg, cfgErr)
+		}
+	}
+	if err := cfgErr.ErrOrNil(); err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	SendSuccess(&Response{})
+}
+
+// checkWebhookURLs sends a HEAD request to every url results are posted to. Endpoints often
+// only accept POST, so any answer but 404 counts as reachable.
+func checkWebhookURLs(cfgErr *ConfigError) {
+	urls := map[string]string{
+		"ew":            *endWebhook,
+		"grafana-url":   *grafanaURL,
+		"usage-webhook": *usageWebhook,
+	}
+	if strings.HasPrefix(*attestOut, "http://") || strings.HasPrefix(*attestOut, "https://") {
+		urls["attest-out"] = *attestOut
+	}
+	for _, name := range []string{"ew", "grafana-url", "usage-webhook", "attest-out"} {
+		url := urls[name]
+		if url == "" {
+			continue
+		}
+		resp, err := webhookClient.Head(url)
+		if err != nil {
+			cfgErr.Add(name, "%s is not reachable: %v", url, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			cfgErr.Add(name, "%s responded %s", url, resp.Status)
+		}
+	}
+}
+
+// checkRBAC asks the api server whether the runner may do everything the rbac subcommand
+// would grant it for the config.
+func checkRBAC(ctx context.Context, clientset *kubernetes.Clientset, cfg *Config, cfgErr *ConfigError) {
+	for _, obj := range BuildRBAC(cfg) {
+		var namespace string
+		var rules []rbacv1.PolicyRule
+		switch role := obj.(type) {
+		case *rbacv1.Role:
+			namespace, rules = role.Namespace, role.Rules
+		case *rbacv1.ClusterRole:
+			rules = role.Rules
+		default:
+			continue
+		}
+		where := "in " + namespace
+		if namespace == "" {
+			where = "cluster wide"
+		}
+		for _, rule := range rules {
+			names := rule.ResourceNames
+			if len(names) == 0 {
+				names = []string{""}
+			}
+			for _, resource := range rule.Resources {
+				resource, subresource := splitResource(resource)
+				for _, verb := range rule.Verbs {
+					for _, name := range names {
+						attrs := &authorizationv1.ResourceAttributes{
+							Namespace:   namespace,
+							Verb:        verb,
+							Group:       rule.APIGroups[0],
+							Resource:    resource,
+							Subresource: subresource,
+							Name:        name,
+						}
+						allowed, err := checkAccess(ctx, clientset, attrs)
+						if err != nil {
+							// the next reviews would fail the same way.
+							cfgErr.Add("f", "%v", err)
+							return
+						}
+						if !allowed {
+							cfgErr.Add("f", "the runner can not %s %s %s", verb, accessString(attrs), where)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func splitResource(resource string) (string, string) {
+	if i := strings.Index(resource, "/"); i >= 0 {
+		return resource[:i], resource[i+1:]
+	}
+	return resource, ""
+}
+
+// accessString describes the resource of the attributes, like pods/exec or configmaps history.
+func accessString(attrs *authorizationv1.ResourceAttributes) string {
+	what := attrs.Resource
+	if attrs.Subresource != "" {
+		what += "/" + attrs.Subresource
+	}
+	if attrs.Name != "" {
+		what += " " + attrs.Name
+	}
+	return what
+}
+
+func checkAccess(ctx context.Context, clientset *kubernetes.Clientset, attrs *authorizationv1.ResourceAttributes) (bool, error) {
+	var review *authorizationv1.SelfSubjectAccessReview
+	err := RetryAPI(ctx, func() error {
+		var err error
+		review, err = clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: attrs,
+			},
+		}, v1.CreateOptions{})
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("check access to %s in %s error: %v", accessString(attrs), attrs.Namespace, err)
+	}
+	return review.Status.Allowed, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const PodStatusSkippedCooldown = "skipped-cooldown"
+
+var cooldown = flag.Duration("cooldown", 0, "skip the run when a run of the same target started less than this long ago, e.g. 30m, the start is recorded in a Lease")
+
+// cooldownClaims are the holders of the cooldown leases this process claimed, by lease name.
+var cooldownClaims = struct {
+	sync.Mutex
+	holders map[string]string
+}{
+	holders: map[string]string{},
+}
+
+func validateCooldown(cfgErr *ConfigError) {
+	if *cooldown < 0 {
+		cfgErr.Add("cooldown", "must not be negative")
+	}
+}
+
+// cooldownLeaseName derives the lease of a target from what it runs where, not from its
+// name, so cron, webhook and manual runs of the same command share it.
+func cooldownLeaseName(target *Target) string {
+	h := sha256.New()
+	for _, s := range []string{target.Namespace, target.PodName, target.Labels, target.FieldSelector(), target.Container, *action, *workload, *postDeploy, *jobName, *service} {
+		fmt.Fprintf(h, "%s\x00", s)
+	}
+	if *statefulSet != "" {
+		// only when set, so the leases of other targets keep their names.
+		fmt.Fprintf(h, "statefulset=%s\x00", *statefulSet)
+	}
+	fmt.Fprintf(h, "%s\x00", strings.Join(target.Command, "\x01"))
+	return "k8s-cronjob-cooldown-" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// ClaimCooldown records the start of a run of the target, nil when -cooldown is not set or
+// passed since the last start. Otherwise the returned response skips the run, or fails it
+// when the lease can not be read or written.
+func ClaimCooldown(ctx context.Context, clientset *kubernetes.Clientset, target *Target) *Response {
+	if *cooldown <= 0 {
+		return nil
+	}
+	runID, err := newRunID()
+	if err != nil {
+		return &Response{
+			Target: target.Name,
+			Error:  err,
+		}
+	}
+	hostname, _ := os.Hostname()
+	holder := hostname + "/" + runID
+	name := cooldownLeaseName(target)
+	var last *coordinationv1.Lease
+	err = RetryAPI(ctx, func() error {
+		last, err = tryClaimCooldown(ctx, clientset, target.Namespace, name, holder)
+		return err
+	})
+	if err != nil {
+		return &Response{
+			Target: target.Name,
+			Error:  fmt.Errorf("claim cooldown lease %s error: %v", name, err),
+		}
+	}
+	if last == nil {
+		cooldownClaims.Lock()
+		cooldownClaims.holders[name] = holder
+		cooldownClaims.Unlock()
+		return nil
+	}
+	reason := fmt.Sprintf("cooldown: a run started less than %s ago", *cooldown)
+	if last.Spec.AcquireTime != nil {
+		since := time.Since(last.Spec.AcquireTime.Time).Round(time.Second)
+		reason = fmt.Sprintf("cooldown: a run started %s ago", since)
+		if last.Spec.HolderIdentity != nil {
+			reason += " by " + *last.Spec.HolderIdentity
+		}
+		reason += fmt.Sprintf(", the next may start in %s", (*cooldown - since).Round(time.Second))
+	}
+	return &Response{
+		Target:     target.Name,
+		SkipReason: reason,
+		Cooldown:   true,
+	}
+}
+
+// ReleaseCooldown gives back the cooldown this process claimed for the target after the run
+// failed, so a retry, e.g. by the backoffLimit of the Job, runs instead of being skipped.
+// The lease is left alone when another run claimed it since.
+func ReleaseCooldown(clientset *kubernetes.Clientset, target *Target) {
+	name := cooldownLeaseName(target)
+	cooldownClaims.Lock()
+	holder, ok := cooldownClaims.holders[name]
+	delete(cooldownClaims.holders, name)
+	cooldownClaims.Unlock()
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	leases := clientset.CoordinationV1().Leases(target.Namespace)
+	err := RetryAPI(ctx, func() error {
+		lease, err := leases.Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+			return nil
+		}
+		lease.Spec.HolderIdentity = nil
+		lease.Spec.AcquireTime = nil
+		_, err = leases.Update(ctx, lease, v1.UpdateOptions{})
+		return err
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		fmt.Fprintf(os.Stderr, "release cooldown lease %s error: %v\n", name, err)
+	}
+}
+
+// tryClaimCooldown takes the lease when it is missing or its run started -cooldown ago, and
+// returns the lease of the earlier run otherwise, an empty one when another runner
+// claimed it just now. Of concurrent runners only one update wins.
+func tryClaimCooldown(ctx context.Context, clientset *kubernetes.Clientset, namespace string, name string, holder string) (*coordinationv1.Lease, error) {
+	leases := clientset.CoordinationV1().Leases(namespace)
+	now := v1.NewMicroTime(time.Now())
+	duration := int32(cooldown.Seconds())
+	lease, err := leases.Get(ctx, name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    managedLabels(),
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		_, err = leases.Create(ctx, lease, v1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			// another runner started just now.
+			return &coordinationv1.Lease{}, nil
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lease.Spec.AcquireTime != nil && time.Since(lease.Spec.AcquireTime.Time) < *cooldown {
+		return lease, nil
+	}
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, v1.UpdateOptions{})
+	if errors.IsConflict(err) {
+		return &coordinationv1.Lease{}, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+var deadlineMargin = flag.Duration("deadline-margin", 30*time.Second, "cancel the command this long before the activeDeadlineSeconds of the runner pod or its job, 0 to ignore the deadline")
+
+// RunnerDeadline returns when kubernetes kills the runner pod, from activeDeadlineSeconds of
+// the pod or of the job owning it.
+func RunnerDeadline(clientset *kubernetes.Clientset) (time.Time, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	pod, err := RunnerPod(ctx, clientset)
+	if err != nil {
+		warnDeadline(err)
+		return time.Time{}, false
+	}
+	namespace := pod.Namespace
+	var deadline time.Time
+	if pod.Spec.ActiveDeadlineSeconds != nil && pod.Status.StartTime != nil {
+		deadline = pod.Status.StartTime.Add(time.Duration(*pod.Spec.ActiveDeadlineSeconds) * time.Second)
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind != "Job" {
+			continue
+		}
+		job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, owner.Name, v1.GetOptions{})
+		if err != nil {
+			warnDeadline(err)
+			break
+		}
+		if job.Spec.ActiveDeadlineSeconds != nil && job.Status.StartTime != nil {
+			jobDeadline := job.Status.StartTime.Add(time.Duration(*job.Spec.ActiveDeadlineSeconds) * time.Second)
+			if deadline.IsZero() || jobDeadline.Before(deadline) {
+				deadline = jobDeadline
+			}
+		}
+	}
+	return deadline, !deadline.IsZero()
+}
+
+var (
+	runnerPodOnce sync.Once
+	runnerPod     *corev1.Pod
+	runnerPodErr  error
+)
+
+// RunnerPod returns the pod k8s-cronjob runs in, found by POD_NAME and POD_NAMESPACE, which
+// can be set through the downward api, falling back to the hostname and the service account
+// namespace. It is read once.
+func RunnerPod(ctx context.Context, clientset *kubernetes.Clientset) (*corev1.Pod, error) {
+	runnerPodOnce.Do(func() {
+		runnerPod, runnerPodErr = getRunnerPod(ctx, clientset)
+	})
+	return runnerPod, runnerPodErr
+}
+
+func getRunnerPod(ctx context.Context, clientset *kubernetes.Clientset) (*corev1.Pod, error) {
+	name := os.Getenv("POD_NAME")
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		b, _ := ioutil.ReadFile(serviceAccountNamespaceFile)
+		namespace = strings.TrimSpace(string(b))
+	}
+	if name == "" || namespace == "" {
+		return nil, errors.NewNotFound(corev1.Resource("pods"), name)
+	}
+	var pod *corev1.Pod
+	err := RetryAPI(ctx, func() (err error) {
+		pod, err = clientset.CoreV1().Pods(namespace).Get(ctx, name, v1.GetOptions{})
+		return err
+	})
+	return pod, err
+}
+
+// RunnerJob returns the job owning the runner pod, nil when it is not run by a job.
+func RunnerJob(ctx context.Context, clientset *kubernetes.Clientset) (*batchv1.Job, error) {
+	pod, err := RunnerPod(ctx, clientset)
+	if err != nil {
+		return nil, err
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "Job" {
+			return clientset.BatchV1().Jobs(pod.Namespace).Get(ctx, owner.Name, v1.GetOptions{})
+		}
+	}
+	return nil, nil
+}
+
+// warnDeadline reports lookup errors other than missing permissions, the deadline is optional.
+func warnDeadline(err error) {
+	if errors.IsForbidden(err) || errors.IsNotFound(err) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "lookup runner deadline error: %v\n", err)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxDiagnosedPods bounds the event lookups done for pending pods.
+const maxDiagnosedPods = 5
+
+// LookupDiagnostics explains why no pod could be picked.
+type LookupDiagnostics struct {
+	// NamespaceExists is nil when the runner may not read namespaces.
+	NamespaceExists *bool          `json:"namespaceExists,omitempty"`
+	Matched         int            `json:"matched"`
+	Phases          map[string]int `json:"phases,omitempty"`
+	Pending         []PendingPod   `json:"pending,omitempty"`
+	Errors          []string       `json:"errors,omitempty"`
+}
+
+type PendingPod struct {
+	Pod     string   `json:"pod"`
+	Reason  string   `json:"reason,omitempty"`
+	Message string   `json:"message,omitempty"`
+	Events  []string `json:"events,omitempty"`
+}
+
+// DiagnoseLookup collects what is known about the pods matching the target, best effort.
+func DiagnoseLookup(clientset *kubernetes.Clientset, namespace string, labels string, fields string, podName string) *LookupDiagnostics {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	diag := &LookupDiagnostics{}
+	_, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, v1.GetOptions{})
+	switch {
+	case err == nil:
+		exists := true
+		diag.NamespaceExists = &exists
+	case errors.IsNotFound(err):
+		exists := false
+		diag.NamespaceExists = &exists
+	case !errors.IsForbidden(err):
+		diag.Errors = append(diag.Errors, fmt.Sprintf("get namespace error: %v", err))
+	}
+	pods, err := ListCandidatePods(ctx, clientset, namespace, labels, fields, podName)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			diag.Errors = append(diag.Errors, fmt.Sprintf("list pods error: %v", err))
+		}
+		return diag
+	}
+	diag.Matched = len(pods)
+	diag.Phases = map[string]int{}
+	for i := range pods {
+		pod := &pods[i]
+		diag.Phases[string(pod.Status.Phase)]++
+		if pod.Status.Phase != corev1.PodPending || len(diag.Pending) >= maxDiagnosedPods {
+			continue
+		}
+		pending := PendingPod{Pod: pod.Name}
+		pending.Reason, pending.Message = pendingReason(pod)
+		events, err := warningEvents(ctx, clientset, pod)
+		if err != nil && !errors.IsForbidden(err) {
+			diag.Errors = append(diag.Errors, fmt.Sprintf("list events of %s error: %v", pod.Name, err))
+		}
+		pending.Events = events
+		diag.Pending = append(diag.Pending, pending)
+	}
+	return diag
+}
+
+// pendingReason reports why a pending pod is not running yet from its status.
+func pendingReason(pod *corev1.Pod) (string, string) {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodScheduled && c.Status != corev1.ConditionTrue {
+			return c.Reason, c.Message
+		}
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, s := range statuses {
+		if s.State.Waiting != nil && s.State.Waiting.Reason != "" {
+			return s.State.Waiting.Reason, s.State.Waiting.Message
+		}
+	}
+	return pod.Status.Reason, pod.Status.Message
+}
+
+// warningEvents returns the latest warning event messages of the pod.
+func warningEvents(ctx context.Context, clientset *kubernetes.Clientset, pod *corev1.Pod) ([]string, error) {
+	events, err := clientset.CoreV1().Events(pod.Namespace).List(ctx, v1.ListOptions{
+		FieldSelector: fields.Set{
+			"involvedObject.name": pod.Name,
+			"involvedObject.uid":  string(pod.UID),
+			"type":                corev1.EventTypeWarning,
+		}.AsSelector().String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	items := events.Items
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].LastTimestamp.Before(&items[j].LastTimestamp)
+	})
+	if len(items) > 3 {
+		items = items[len(items)-3:]
+	}
+	var msgs []string
+	for _, e := range items {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", e.Reason, e.Message))
+	}
+	return msgs, nil
+}
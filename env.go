@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+)
+
+const envPrefix = "K8S_CRONJOB_"
+
+// EnvName returns the environment variable configuring a flag, e.g. history-cm is K8S_CRONJOB_HISTORY_CM.
+func EnvName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// ApplyEnv sets every flag not given on the command line from its environment variable.
+// Repeatable flags take a comma separated list.
+func ApplyEnv(fs *flag.FlagSet) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	cfgErr := &ConfigError{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(EnvName(f.Name))
+		if !ok {
+			return
+		}
+		values := []string{value}
+		if _, repeatable := f.Value.(*stringsFlag); repeatable {
+			values = strings.Split(value, ",")
+		}
+		for _, v := range values {
+			if err := fs.Set(f.Name, v); err != nil {
+				cfgErr.Add(f.Name, "invalid value %q from %s: %v", v, EnvName(f.Name), err)
+			}
+		}
+	})
+	return cfgErr.ErrOrNil()
+}
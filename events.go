@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	EventLookupStarted = "lookup-started"
+	EventPodSelected   = "pod-selected"
+	EventExecStarted   = "exec-started"
+	EventOutputChunk   = "output-chunk"
+	EventFinished      = "finished"
+)
+
+var eventsNDJSON = flag.Bool("events-ndjson", false, "print newline delimited json events while running, the result is the last one, a finished event")
+
+var eventsMu sync.Mutex
+
+// EmitEvent prints an event line when -events-ndjson is set, and updates -status-file.
+func EmitEvent(event string, fields map[string]interface{}) {
+	updateProgress(event, fields)
+	if !*eventsNDJSON {
+		return
+	}
+	line := map[string]interface{}{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"event": event,
+	}
+	for k, v := range fields {
+		line[k] = v
+	}
+	b, _ := json.Marshal(line)
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	WriteLine(os.Stdout, b)
+}
+
+// eventWriter emits the writes as redacted output-chunk events of whole lines before
+// passing them on, Flush emits the last line.
+type eventWriter struct {
+	w      io.Writer
+	pod    string
+	stream string
+	redact lineRedactor
+}
+
+func (e *eventWriter) Write(p []byte) (int, error) {
+	e.emit(e.redact.lines(p))
+	return e.w.Write(p)
+}
+
+// Flush emits what followed the last newline.
+func (e *eventWriter) Flush() {
+	e.emit(e.redact.flush())
+}
+
+func (e *eventWriter) emit(data string) {
+	if data == "" {
+		return
+	}
+	EmitEvent(EventOutputChunk, map[string]interface{}{
+		"pod":    e.pod,
+		"stream": e.stream,
+		"data":   data,
+	})
+}
+
+// Reset passes a restart of the stream on to the wrapped writer, the open line of the
+// dropped stream is emitted.
+func (e *eventWriter) Reset() error {
+	e.Flush()
+	return resetWriter(e.w)
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// PodExpr is a small boolean expression over pod fields, e.g.
+//
+//	status.phase==Running && (conditions.Ready==True || metadata.labels.role==primary)
+//
+// Comparisons are joined by && and ||, && binds tighter, ! negates and parentheses group.
+// A comparison is `path op value` with op one of == != < <= > >=, or a bare path which is
+// true when the field is set and not false/empty. Values with spaces or operator characters
+// are quoted with ' or ". Values compare as numbers or RFC3339 times when both sides parse as
+// such, now, now-1h or now+30m is the current time moved by a duration when compared.
+type PodExpr struct {
+	src  string
+	root exprNode
+}
+
+// exprNode is a node of the parsed expression.
+type exprNode interface {
+	match(obj map[string]interface{}) (bool, error)
+	// requiresRunning reports whether the node only matches pods with status.phase==Running.
+	requiresRunning() bool
+}
+
+type exprOr []exprNode
+
+type exprAnd []exprNode
+
+type exprNot struct {
+	node exprNode
+}
+
+type exprComparison struct {
+	path  []string
+	op    string
+	value string
+}
+
+var exprOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// exprSymbols are the operators the tokenizer knows, longer ones first.
+var exprSymbols = append([]string{"&&", "||"}, append(exprOperators, "!")...)
+
+// exprToken is a token of an expression: an operator, a parenthesis, a word or a quoted string.
+type exprToken struct {
+	text   string
+	pos    int
+	quoted bool
+}
+
+// tokenizeExpr splits src into tokens. A word runs up to whitespace, a parenthesis or an
+// operator; [...] in a word, a path segment with dots, is kept whole.
+func tokenizeExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '(' || c == ')':
+			tokens = append(tokens, exprToken{text: src[i : i+1], pos: i})
+			i++
+			continue
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed %c at offset %d", c, i)
+			}
+			tokens = append(tokens, exprToken{text: src[i+1 : i+1+end], pos: i, quoted: true})
+			i += end + 2
+			continue
+		}
+		if op := exprOperatorAt(src, i); op != "" {
+			tokens = append(tokens, exprToken{text: op, pos: i})
+			i += len(op)
+			continue
+		}
+		start := i
+		for i < len(src) && !strings.ContainsRune(" \t\n\r()'\"", rune(src[i])) && exprOperatorAt(src, i) == "" {
+			if src[i] == '[' {
+				end := strings.IndexByte(src[i:], ']')
+				if end < 0 {
+					return nil, fmt.Errorf("unclosed [ at offset %d", i)
+				}
+				i += end
+			}
+			i++
+		}
+		tokens = append(tokens, exprToken{text: src[start:i], pos: start})
+	}
+	return tokens, nil
+}
+
+// exprOperatorAt returns the operator starting at src[i], "" when there is none.
+func exprOperatorAt(src string, i int) string {
+	for _, op := range exprSymbols {
+		if strings.HasPrefix(src[i:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// exprParser is a recursive descent parser over the tokens:
+//
+//	or         = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | "(" or ")" | comparison
+//	comparison = path [ op value ]
+type exprParser struct {
+	src    string
+	tokens []exprToken
+	next   int
+}
+
+func ParsePodExpr(src string) (*PodExpr, error) {
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{src: src, tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.next < len(p.tokens) {
+		return nil, p.unexpected()
+	}
+	return &PodExpr{src: src, root: root}, nil
+}
+
+// peek returns the next operator or parenthesis, "" for a word, a string or the end.
+func (p *exprParser) peek() string {
+	if p.next >= len(p.tokens) || p.tokens[p.next].quoted {
+		return ""
+	}
+	t := p.tokens[p.next].text
+	if t == "(" || t == ")" || exprOperatorAt(t, 0) == t {
+		return t
+	}
+	return ""
+}
+
+func (p *exprParser) unexpected() error {
+	if p.next >= len(p.tokens) {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.next]
+	return fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	var or exprOr
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, node)
+		if p.peek() != "||" {
+			break
+		}
+		p.next++
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	var and exprAnd
+	for {
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, node)
+		if p.peek() != "&&" {
+			break
+		}
+		p.next++
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	switch p.peek() {
+	case "!":
+		p.next++
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprNot{node: node}, nil
+	case "(":
+		p.next++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, p.unexpected()
+		}
+		p.next++
+		return node, nil
+	case "":
+		return p.parseComparison()
+	}
+	return nil, p.unexpected()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	if p.next >= len(p.tokens) || p.tokens[p.next].quoted {
+		return nil, p.unexpected()
+	}
+	path, err := ParseFieldPath(p.tokens[p.next].text)
+	if err != nil {
+		return nil, fmt.Errorf("%v at offset %d", err, p.tokens[p.next].pos)
+	}
+	p.next++
+	c := exprComparison{path: path}
+	op := p.peek()
+	for _, o := range exprOperators {
+		if op != o {
+			continue
+		}
+		p.next++
+		if p.next >= len(p.tokens) || (!p.tokens[p.next].quoted && p.peek() != "") {
+			return nil, p.unexpected()
+		}
+		c.op, c.value = op, p.tokens[p.next].text
+		p.next++
+		break
+	}
+	return c, nil
+}
+
+// ParseFieldPath splits a dotted path, segments containing dots are written as ['a.b/c'].
+func ParseFieldPath(s string) ([]string, error) {
+	var path []string
+	for s != "" {
+		if strings.HasPrefix(s, "[") {
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in field path")
+			}
+			path = append(path, unquote(s[1:end]))
+			s = strings.TrimPrefix(s[end+1:], ".")
+			continue
+		}
+		end := strings.IndexAny(s, ".[")
+		if end < 0 {
+			end = len(s)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("empty field path segment")
+		}
+		path = append(path, s[:end])
+		s = strings.TrimPrefix(s[end:], ".")
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty field path")
+	}
+	return path, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// RequiresRunning reports whether every pod matching e has status.phase==Running.
+func (e *PodExpr) RequiresRunning() bool {
+	return e.root.requiresRunning()
+}
+
+func (or exprOr) requiresRunning() bool {
+	for _, node := range or {
+		if !node.requiresRunning() {
+			return false
+		}
+	}
+	return true
+}
+
+func (and exprAnd) requiresRunning() bool {
+	for _, node := range and {
+		if node.requiresRunning() {
+			return true
+		}
+	}
+	return false
+}
+
+func (n exprNot) requiresRunning() bool {
+	return false
+}
+
+func (c exprComparison) requiresRunning() bool {
+	phase := (len(c.path) == 2 && c.path[0] == "status" && c.path[1] == "phase") || (len(c.path) == 1 && c.path[0] == "phase")
+	return phase && c.op == "==" && c.value == string(corev1.PodRunning)
+}
+
+func (e *PodExpr) String() string {
+	return e.src
+}
+
+func (e *PodExpr) Match(pod *corev1.Pod) (bool, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+	if err != nil {
+		return false, err
+	}
+	return e.root.match(obj)
+}
+
+func (or exprOr) match(obj map[string]interface{}) (bool, error) {
+	for _, node := range or {
+		if ok, err := node.match(obj); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func (and exprAnd) match(obj map[string]interface{}) (bool, error) {
+	for _, node := range and {
+		if ok, err := node.match(obj); err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (n exprNot) match(obj map[string]interface{}) (bool, error) {
+	ok, err := n.node.match(obj)
+	return !ok && err == nil, err
+}
+
+func (c exprComparison) match(obj map[string]interface{}) (bool, error) {
+	field, found := LookupField(obj, c.path)
+	if c.op == "" {
+		return found && truthy(field), nil
+	}
+	if !found {
+		return c.op == "!=", nil
+	}
+	value := resolveExprValue(c.value)
+	cmp, ok := compareValues(fmt.Sprint(field), value)
+	switch c.op {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	}
+	if !ok {
+		return false, fmt.Errorf("can not order %s %q and %q", strings.Join(c.path, "."), fmt.Sprint(field), value)
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+// resolveExprValue turns now, now-<duration> and now+<duration> into an RFC3339 time, other
+// values are returned unchanged.
+func resolveExprValue(value string) string {
+	if !strings.HasPrefix(value, "now") {
+		return value
+	}
+	offset := strings.TrimSpace(strings.TrimPrefix(value, "now"))
+	var d time.Duration
+	if offset != "" {
+		var err error
+		if d, err = time.ParseDuration(strings.TrimPrefix(offset, "+")); err != nil || (offset[0] != '-' && offset[0] != '+') {
+			return value
+		}
+	}
+	return time.Now().Add(d).UTC().Format(time.RFC3339)
+}
+
+// compareValues compares a and b as numbers, times or strings. ok is false when
+// the values are only comparable as strings.
+func compareValues(a string, b string) (int, bool) {
+	if x, err := strconv.ParseFloat(a, 64); err == nil {
+		if y, err := strconv.ParseFloat(b, 64); err == nil {
+			return compareFloats(x, y), true
+		}
+	}
+	if x, err := time.Parse(time.RFC3339, a); err == nil {
+		if y, err := time.Parse(time.RFC3339, b); err == nil {
+			return compareFloats(float64(x.UnixNano()), float64(y.UnixNano())), true
+		}
+	}
+	return strings.Compare(a, b), false
+}
+
+func compareFloats(x float64, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != "" && !strings.EqualFold(v, "false")
+	}
+	return true
+}
+
+// podFieldShortcuts are single segment paths standing for a longer one.
+var podFieldShortcuts = map[string][]string{
+	"name":              {"metadata", "name"},
+	"creationTimestamp": {"metadata", "creationTimestamp"},
+	"nodeName":          {"spec", "nodeName"},
+	"phase":             {"status", "phase"},
+	"podIP":             {"status", "podIP"},
+	"qosClass":          {"status", "qosClass"},
+	"startTime":         {"status", "startTime"},
+}
+
+// LookupField resolves path in the unstructured pod. conditions.<Type> is a shortcut
+// for the status of that pod condition, restartCount the restarts of all its containers and
+// podFieldShortcuts name common fields without their parents.
+func LookupField(obj map[string]interface{}, path []string) (interface{}, bool) {
+	if len(path) == 1 {
+		if path[0] == "restartCount" {
+			status, _ := obj["status"].(map[string]interface{})
+			statuses, _ := status["containerStatuses"].([]interface{})
+			var restarts int64
+			for _, s := range statuses {
+				containerStatus, _ := s.(map[string]interface{})
+				n, _ := containerStatus["restartCount"].(int64)
+				restarts += n
+			}
+			return restarts, true
+		}
+		if full, ok := podFieldShortcuts[path[0]]; ok {
+			path = full
+		}
+	}
+	if len(path) == 2 && path[0] == "conditions" {
+		status, _ := obj["status"].(map[string]interface{})
+		conditions, _ := status["conditions"].([]interface{})
+		for _, c := range conditions {
+			condition, _ := c.(map[string]interface{})
+			if condition["type"] == path[1] {
+				return condition["status"], true
+			}
+		}
+		return nil, false
+	}
+	var cur interface{} = obj
+	for _, segment := range path {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			next, ok := v[segment]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			cur = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// PodRank orders pods by a field path, the pod with the highest value ranks first.
+// A leading - ranks the lowest value first. Pods missing the field rank last.
+type PodRank struct {
+	path    []string
+	reverse bool
+}
+
+func ParsePodRank(src string) (*PodRank, error) {
+	r := &PodRank{}
+	if strings.HasPrefix(src, "-") {
+		r.reverse = true
+		src = src[1:]
+	}
+	path, err := ParseFieldPath(strings.TrimSpace(src))
+	if err != nil {
+		return nil, err
+	}
+	r.path = path
+	return r, nil
+}
+
+// Better reports whether pod a ranks above pod b.
+func (r *PodRank) Better(a *corev1.Pod, b *corev1.Pod) bool {
+	av, aok := r.value(a)
+	bv, bok := r.value(b)
+	if !aok || !bok {
+		return aok && !bok
+	}
+	cmp, _ := compareValues(av, bv)
+	if r.reverse {
+		return cmp < 0
+	}
+	return cmp > 0
+}
+
+func (r *PodRank) value(pod *corev1.Pod) (string, bool) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+	if err != nil {
+		return "", false
+	}
+	v, ok := LookupField(obj, r.path)
+	if !ok || v == nil {
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"regexp"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// maxDiagnosticOutput bounds the output kept of every diagnostic command.
+	maxDiagnosticOutput = 4096
+	diagnosticTimeout   = 10 * time.Second
+)
+
+var (
+	diagnoseOnFailure = flag.Bool("diagnose-on-failure", false, "when the command fails, run diagnostic commands in the same container and add their output to the result under diagnostics")
+	diagnoseCmds      stringsFlag
+
+	defaultDiagnoseCmds = map[string][]string{
+		OSLinux:   {"env", "df -h", "free -m", "ps aux"},
+		OSWindows: {"set", "systeminfo"},
+	}
+
+	// secretEnvLine matches env lines of variables named like secrets, their values are hidden.
+	secretEnvLine = regexp.MustCompile(`(?im)^([A-Z0-9_]*(PASS|SECRET|TOKEN|KEY|CREDENTIAL)[A-Z0-9_]*)=.*$`)
+)
+
+func init() {
+	flag.Var(&diagnoseCmds, "diagnose-cmd", "shell command run by -diagnose-on-failure instead of the defaults env, df -h, free -m and ps aux, e.g. 'tail -n 100 /var/log/app.log', repeatable")
+}
+
+// DiagnosticOutput is the output of a diagnostic command run after a failure.
+type DiagnosticOutput struct {
+	Command string `json:"command"`
+	Stdout  string `json:"stdout"`
+	Stderr  string `json:"stderr,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RunFailureDiagnostics runs the -diagnose-cmd commands, or the defaults of the target os,
+// one after another through the default shell. Output is redacted and cut.
+func RunFailureDiagnostics(clientset *kubernetes.Clientset, config *rest.Config, namespace string, podName string, containerName string) []*DiagnosticOutput {
+	cmds := []string(diagnoseCmds)
+	if len(cmds) == 0 {
+		cmds = defaultDiagnoseCmds[*targetOS]
+	}
+	outputs := make([]*DiagnosticOutput, 0, len(cmds))
+	for _, cmd := range cmds {
+		// the run may have failed because it was cancelled, the diagnostics get their own time.
+		ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+		args, _ := ShellArgs(DefaultShell(), cmd)
+		stdout, stderr, err := ExecInPod(ctx, clientset, config, namespace, podName, containerName, args)
+		cancel()
+		output := &DiagnosticOutput{
+			Command: cmd,
+			Stdout:  cutUTF8(hideSecretEnv(Redact(stdout)), maxDiagnosticOutput),
+			Stderr:  cutUTF8(Redact(stderr), maxDiagnosticOutput),
+		}
+		if err != nil && stderr == "" {
+			output.Error = Redact(err.Error())
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs
+}
+
+func hideSecretEnv(s string) string {
+	return secretEnvLine.ReplaceAllString(s, "$1="+redacted)
+}
+
+func validateDiagnoseOnFailure(cfgErr *ConfigError) {
+	if len(diagnoseCmds) > 0 && !*diagnoseOnFailure {
+		cfgErr.Add("diagnose-cmd", "requires -diagnose-on-failure")
+	}
+	for _, cmd := range diagnoseCmds {
+		if strings.TrimSpace(cmd) == "" {
+			cfgErr.Add("diagnose-cmd", "must not be empty")
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	PodStatusSucceeded = "succeeded"
+	PodStatusFailed    = "failed"
+	PodStatusSkipped   = "skipped"
+	PodStatusCancelled = "cancelled"
+)
+
+var (
+	failFast    = flag.Bool("fail-fast", false, "with -all, start no further pod once a pod failed, or once -min-success can not be reached anymore")
+	minSuccess  = flag.String("min-success", "100%", "with -all, the run succeeds when at least this percentage of the eligible pods succeeded, e.g. 80%")
+	stagger     = flag.Duration("stagger", 0, "with -all, pause this long before starting each further pod, with -parallel 1 after the previous pod finished")
+	canaryFirst = flag.Bool("canary-first", false, "with -all, run on one pod first, picked like a single run, and on the others only when it succeeded")
+)
+
+func validateFanOutThresholds(cfgErr *ConfigError) {
+	if *stagger < 0 {
+		cfgErr.Add("stagger", "must not be negative")
+	}
+	if _, err := parseMinSuccess(*minSuccess); err != nil {
+		cfgErr.Add("min-success", "%v", err)
+	}
+	if !*allPods {
+		if *failFast {
+			cfgErr.Add("fail-fast", "requires -all")
+		}
+		if *minSuccess != "100%" {
+			cfgErr.Add("min-success", "requires -all")
+		}
+		if *stagger != 0 {
+			cfgErr.Add("stagger", "requires -all")
+		}
+		if *canaryFirst {
+			cfgErr.Add("canary-first", "requires -all")
+		}
+	}
+}
+
+// parseMinSuccess parses a percentage like 80% or 80.
+func parseMinSuccess(s string) (float64, error) {
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || pct < 0 || pct > 100 {
+		return 0, fmt.Errorf("must be a percentage between 0%% and 100%%")
+	}
+	return pct, nil
+}
+
+type PodResult struct {
+	Pod        string        `json:"pod"`
+	Status     string        `json:"status"`
+	Duration   string        `json:"duration,omitempty"`
+	Stdout     string        `json:"stdout,omitempty"`
+	Stderr     string        `json:"stderr,omitempty"`
+	StdoutFile string        `json:"stdoutFile,omitempty"`
+	StderrFile string        `json:"stderrFile,omitempty"`
+	Verify     *VerifyResult `json:"verify,omitempty"`
+	Usage      *UsageStats   `json:"usage,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// BatchReport summarizes a run against every matched pod.
+type BatchReport struct {
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Skipped   int          `json:"skipped"`
+	Duration  string       `json:"duration"`
+	Table     string       `json:"table"`
+	Pods      []*PodResult `json:"pods"`
+	// Divergent and Diff are set by -expect-identical-stdout.
+	Divergent []string `json:"divergent,omitempty"`
+	Diff      string   `json:"diff,omitempty"`
+
+	// tolerated is how many eligible pods may fail with -min-success, notRun the pods -fail-fast,
+	// -canary-first or a cancellation left out.
+	tolerated int
+	notRun    int
+	// unhealthy are the eligible pods skipped by the health check, they did not succeed.
+	unhealthy int
+	// aborted tells why -canary-first ran no further pod, or that the run was cancelled.
+	aborted string
+	// err is set when the pods or their statefulset could not be read.
+	err error
+}
+
+// Err fails the run when fewer pods than -min-success requires succeeded, or their stdout
+// differs with -expect-identical-stdout. Pods skipped as unhealthy did not succeed.
+func (r *BatchReport) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.unhealthy > 0 && r.Succeeded+r.Failed+r.notRun == 0 {
+		return fmt.Errorf("all %d eligible pods were skipped as unhealthy", r.unhealthy)
+	}
+	if r.Failed+r.unhealthy <= r.tolerated && r.notRun == 0 {
+		if len(r.Divergent) > 0 {
+			return fmt.Errorf("stdout of %d of %d pods differs: %s", len(r.Divergent), r.Succeeded, strings.Join(r.Divergent, ", "))
+		}
+		return nil
+	}
+	total := r.Succeeded + r.Failed + r.unhealthy + r.notRun
+	if r.aborted != "" {
+		return fmt.Errorf("%s, the other %d pods were not run", r.aborted, r.notRun)
+	}
+	failed := fmt.Sprintf("%d of %d pods failed", r.Failed, total)
+	if r.unhealthy > 0 {
+		failed += fmt.Sprintf(", %d skipped as unhealthy", r.unhealthy)
+	}
+	if r.notRun > 0 {
+		return fmt.Errorf("%s, -fail-fast skipped %d", failed, r.notRun)
+	}
+	if *minSuccess != "100%" {
+		return fmt.Errorf("%s, less than -min-success %s succeeded", failed, *minSuccess)
+	}
+	return errors.New(failed)
+}
+
+// RunFanOut executes the target command in every eligible pod matched by the target labels,
+// at most -parallel at a time and -stagger apart, with -canary-first in one pod before the
+// others. Other pods are reported as skipped.
+func RunFanOut(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, target *Target) *BatchReport {
+	start := time.Now()
+	report := &BatchReport{}
+	var pods []corev1.Pod
+	var err error
+	if *waitRunningPodTimeout > 0 {
+		// wait for the first running pod, the rest are picked up by the list below.
+		if _, err = LookupRunningPodTimeout(ctx, clientset, target.Namespace, target.Labels, target.FieldSelector(), "", target.Container, *waitRunningPodTimeout); err != nil {
+			err = fmt.Errorf("lookup running pod error: %v", err)
+		}
+	}
+	if err == nil {
+		if pods, err = ListCandidatePods(ctx, clientset, target.Namespace, target.Labels, target.FieldSelector(), ""); err != nil {
+			err = fmt.Errorf("list pods error: %v", err)
+		}
+	}
+	if err != nil {
+		report.err = err
+		report.Failed = 1
+		report.Pods = append(report.Pods, &PodResult{
+			Status: PodStatusFailed,
+			Error:  err.Error(),
+		})
+		report.Duration = time.Since(start).String()
+		report.Table = report.FormatTable()
+		return report
+	}
+	report.Pods = make([]*PodResult, len(pods))
+	var order []int
+	for i := range pods {
+		if !IsEligiblePod(&pods[i]) {
+			report.Pods[i] = &PodResult{
+				Pod:    pods[i].Name,
+				Status: PodStatusSkipped,
+				Error:  fmt.Sprintf("pod is %s and does not match -wait-for or -where", pods[i].Status.Phase),
+			}
+			continue
+		}
+		order = append(order, i)
+	}
+	if canary := PickPod(pods); *canaryFirst && canary != nil {
+		for n, i := range order {
+			if &pods[i] == canary {
+				copy(order[1:n+1], order[:n])
+				order[0] = i
+				break
+			}
+		}
+	}
+	pct, _ := parseMinSuccess(*minSuccess)
+	report.tolerated = len(order) - int(math.Ceil(float64(len(order))*pct/100))
+	var failed int32
+	sem := make(chan struct{}, *parallel)
+	var wg sync.WaitGroup
+	for n, i := range order {
+		pod := pods[i]
+		if n == 1 && *canaryFirst {
+			wg.Wait()
+			if canary := report.Pods[order[0]]; canary.Status != PodStatusSucceeded {
+				report.aborted = fmt.Sprintf("canary pod %s %s", canary.Pod, canary.Status)
+				for _, i := range order[1:] {
+					report.notRun++
+					report.Pods[i] = &PodResult{
+						Pod:    pods[i].Name,
+						Status: PodStatusSkipped,
+						Error:  "not run, the canary pod " + canary.Status,
+					}
+				}
+				break
+			}
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			report.cancelled(i, pod.Name, ctx.Err())
+			continue
+		}
+		if *failFast && int(atomic.LoadInt32(&failed)) > report.tolerated {
+			<-sem
+			report.notRun++
+			report.Pods[i] = &PodResult{
+				Pod:    pod.Name,
+				Status: PodStatusSkipped,
+				Error:  "not run, -fail-fast after a failure",
+			}
+			continue
+		}
+		if n > 0 && *stagger > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(*stagger):
+			}
+		}
+		if ctx.Err() != nil {
+			<-sem
+			report.cancelled(i, pod.Name, ctx.Err())
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			report.Pods[i] = runInPod(ctx, clientset, config, target, pod.Name)
+			if status := report.Pods[i].Status; status == PodStatusFailed || status == PodStatusSkippedUnhealthy {
+				atomic.AddInt32(&failed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, result := range report.Pods {
+		switch result.Status {
+		case PodStatusSucceeded:
+			report.Succeeded++
+		case PodStatusFailed:
+			report.Failed++
+		case PodStatusSkippedUnhealthy:
+			report.Skipped++
+			report.unhealthy++
+		default:
+			report.Skipped++
+		}
+	}
+	if *expectIdenticalStdout {
+		report.compareStdout()
+	}
+	report.Duration = time.Since(start).String()
+	report.Table = report.FormatTable()
+	return report
+}
+
+// cancelled records a pod that was not started because the run was cancelled.
+func (r *BatchReport) cancelled(i int, podName string, err error) {
+	if r.aborted == "" {
+		r.aborted = fmt.Sprintf("run cancelled (%v)", err)
+	}
+	r.notRun++
+	r.Pods[i] = &PodResult{
+		Pod:    podName,
+		Status: PodStatusCancelled,
+		Error:  "not run, cancelled",
+	}
+}
+
+func runInPod(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, target *Target, podName string) *PodResult {
+	resp := RunInPod(ctx, clientset, config, target, podName, nil)
+	result := &PodResult{
+		Pod:        podName,
+		Status:     PodStatusSucceeded,
+		Duration:   resp.Duration,
+		Stdout:     resp.Stdout,
+		Stderr:     resp.Stderr,
+		StdoutFile: resp.StdoutFile,
+		StderrFile: resp.StderrFile,
+		Verify:     resp.Verify,
+		Usage:      resp.Usage,
+	}
+	if resp.SkipReason != "" {
+		result.Status = PodStatusSkippedUnhealthy
+		result.Error = resp.SkipReason
+	} else if resp.Error != nil {
+		result.Status = PodStatusFailed
+		result.Error = resp.Error.Error()
+	}
+	if *endWebhook != "" && *endWebhookPerPod {
+		if err := PostWebhook(*endWebhook, EndWebhookReply(resp)); err != nil {
+			fmt.Fprintf(os.Stderr, "end webhook error: %v\n", err)
+		}
+	}
+	return result
+}
+
+// FormatTable renders one line per pod, errors are cut to keep chat messages short.
+func (r *BatchReport) FormatTable() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "POD\tSTATUS\tDURATION\tERROR")
+	for _, result := range r.Pods {
+		msg := strings.ReplaceAll(result.Error, "\n", " ")
+		if len(msg) > 80 {
+			msg = msg[:77] + "..."
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Pod, result.Status, result.Duration, msg)
+	}
+	w.Flush()
+	return b.String()
+}
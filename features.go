@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+var verbose = flag.Bool("verbose", false, "print the detected api server version and features and the code paths chosen for them on stderr")
+
+// ServerFeatures is what the api server supports of what k8s-cronjob can use. Until the
+// detection ran, or when it failed, everything counts as available and the code paths fall
+// back on errors as before.
+type ServerFeatures struct {
+	Version string
+	Minor   int
+	// EndpointSlices is discovery.k8s.io/v1, since 1.21.
+	EndpointSlices bool
+	// EphemeralContainers is the pods/ephemeralcontainers subresource, since 1.23 by default.
+	EphemeralContainers bool
+	// WebSocketExec is exec over websockets, since 1.30 by default. This client speaks spdy only.
+	WebSocketExec bool
+}
+
+var serverFeatures = &ServerFeatures{
+	EndpointSlices:      true,
+	EphemeralContainers: true,
+}
+
+// DetectServerFeatures asks the discovery api of the cluster what it supports, so the same
+// binary picks compatible code paths from 1.22 on. Failures keep the defaults.
+func DetectServerFeatures(clientset *kubernetes.Clientset) {
+	discovery := clientset.Discovery()
+	info, err := discovery.ServerVersion()
+	if err != nil {
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "detect server version error: %v\n", err)
+		}
+		return
+	}
+	features := &ServerFeatures{
+		Version:             info.GitVersion,
+		Minor:               serverMinor(info.Minor),
+		EndpointSlices:      true,
+		EphemeralContainers: true,
+	}
+	features.WebSocketExec = features.Minor >= 30
+	if _, err := discovery.ServerResourcesForGroupVersion("discovery.k8s.io/v1"); errors.IsNotFound(err) {
+		features.EndpointSlices = false
+	}
+	if core, err := discovery.ServerResourcesForGroupVersion("v1"); err == nil {
+		features.EphemeralContainers = false
+		for _, resource := range core.APIResources {
+			if resource.Name == "pods/ephemeralcontainers" {
+				features.EphemeralContainers = true
+			}
+		}
+	}
+	serverFeatures = features
+	if *verbose {
+		fmt.Fprintf(os.Stderr, "server %s: %s\n", features.Version, features.Paths())
+	}
+}
+
+// serverMinor parses a minor version like "27" or "27+" of managed clusters, 0 when unknown.
+func serverMinor(minor string) int {
+	n, _ := strconv.Atoi(strings.TrimRight(minor, "+"))
+	return n
+}
+
+// Paths describes the detected features and the code paths chosen for them.
+func (f *ServerFeatures) Paths() string {
+	var paths []string
+	if f.EndpointSlices {
+		paths = append(paths, "-service pods from endpointslices")
+	} else {
+		paths = append(paths, "-service pods from endpoints, no discovery.k8s.io/v1")
+	}
+	exec := "exec over spdy"
+	if f.WebSocketExec {
+		exec += ", websocket exec is supported by the server but not this client"
+	}
+	paths = append(paths, exec)
+	if f.EphemeralContainers {
+		paths = append(paths, "ephemeral containers available")
+	} else {
+		paths = append(paths, "no ephemeral containers")
+	}
+	return strings.Join(paths, "; ")
+}
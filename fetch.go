@@ -0,0 +1,232 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	fetchPath = flag.String("path", "", "absolute path of the file or directory -action fetch copies out of the container")
+	fetchTo   = flag.String("fetch-to", "", "where -action fetch writes -path: a local file, {{pod}} is replaced by the pod name, or an http(s) url the content is PUT to, e.g. a presigned object storage url; default the base name of -path, a directory as <name>.tar")
+)
+
+func validateFetch(cfgErr *ConfigError) {
+	if *action != ActionFetch {
+		for _, name := range []string{"path", "fetch-to"} {
+			if flag.Lookup(name).Value.String() != "" {
+				cfgErr.Add(name, "requires -action fetch")
+			}
+		}
+		return
+	}
+	if *fetchPath == "" {
+		cfgErr.Add("path", "is required with -action fetch")
+	} else if !path.IsAbs(*fetchPath) || path.Clean(*fetchPath) == "/" {
+		cfgErr.Add("path", "must be an absolute path below /")
+	}
+	if isURL(*fetchTo) {
+		if u, err := url.Parse(*fetchTo); err != nil || u.Host == "" {
+			cfgErr.Add("fetch-to", "malformed url")
+		}
+	}
+	if *allPods && !strings.Contains(*fetchTo, "{{pod}}") {
+		cfgErr.Add("fetch-to", "must contain {{pod}} with -all, every pod writes its own copy")
+	}
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// Fetch copies -path out of the container through tar over exec and verifies the sha256 of
+// every file against sha256sum run in the container. A single file is written as is, a
+// directory as the tar archive. The stdout lists the verified files like sha256sum.
+func Fetch(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, namespace string, podName string, containerName string) (*ExecOutput, error) {
+	out := &ExecOutput{}
+	dir, base := path.Split(path.Clean(*fetchPath))
+	dest := outputFileName(*fetchTo, podName)
+	tmpDir := filepath.Dir(dest)
+	if dest == "" || isURL(dest) {
+		tmpDir = ""
+	}
+	archive, err := ioutil.TempFile(tmpDir, ".k8s-cronjob-fetch-")
+	if err != nil {
+		return out, fmt.Errorf("create fetch file error: %v", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	var stderr syncBuffer
+	written := &countingWriter{w: archive}
+	err = ExecInPodTo(ctx, clientset, config, namespace, podName, containerName, []string{"tar", "cf", "-", "-C", dir, base}, nil, written, &stderr)
+	if err != nil {
+		return out, fmt.Errorf("tar %s error: %v %s", *fetchPath, err, strings.TrimSpace(stderr.String()))
+	}
+	if policy.MaxOutputBytes > 0 && written.Count() > policy.MaxOutputBytes {
+		// the policy cut the archive and appended its truncation note.
+		return out, fmt.Errorf("the tar archive of %s exceeds maxOutputBytes %d of policy %s", *fetchPath, policy.MaxOutputBytes, policyFile)
+	}
+	sums, _, err := ExecInPod(ctx, clientset, config, namespace, podName, containerName, []string{"sh", "-c", `cd "$1" && find "$2" -type f -exec sha256sum {} +`, "sh", dir, base})
+	if err != nil {
+		return out, fmt.Errorf("sha256sum %s error: %v", *fetchPath, err)
+	}
+	remote := map[string]string{}
+	for _, line := range strings.Split(sums, "\n") {
+		if fields := strings.SplitN(line, "  ", 2); len(fields) == 2 {
+			remote[fields[1]] = fields[0]
+		}
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return out, err
+	}
+	local, single, err := tarDigests(archive)
+	if err != nil {
+		return out, fmt.Errorf("read tar of %s error: %v", *fetchPath, err)
+	}
+	if err := compareDigests(remote, local); err != nil {
+		return out, err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return out, err
+	}
+	info, err := archive.Stat()
+	if err != nil {
+		return out, err
+	}
+	content, size := io.Reader(archive), info.Size()
+	if single {
+		tr := tar.NewReader(archive)
+		header, err := tr.Next()
+		if err != nil {
+			return out, fmt.Errorf("read tar of %s error: %v", *fetchPath, err)
+		}
+		content, size = tr, header.Size
+	} else if dest == "" {
+		dest = base + ".tar"
+	}
+	if dest == "" {
+		dest = base
+	}
+	if isURL(dest) {
+		err = uploadFetched(ctx, dest, content, size)
+	} else {
+		err = writeFetched(dest, content)
+	}
+	if err != nil {
+		return out, err
+	}
+	var lines []string
+	for name, sum := range local {
+		lines = append(lines, sum+"  "+name)
+	}
+	sort.Strings(lines)
+	out.Stdout = strings.Join(lines, "\n")
+	return out, nil
+}
+
+// tarDigests returns the sha256 of every regular file in the archive, and whether the
+// archive is just one file.
+func tarDigests(r io.Reader) (map[string]string, bool, error) {
+	digests := map[string]string{}
+	entries, files := 0, 0
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		entries++
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		files++
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return nil, false, err
+		}
+		digests[header.Name] = hex.EncodeToString(h.Sum(nil))
+	}
+	return digests, entries == 1 && files == 1, nil
+}
+
+// compareDigests fails on a file missing on one side or with different content.
+func compareDigests(remote map[string]string, local map[string]string) error {
+	for name, sum := range remote {
+		if local[name] == "" {
+			return fmt.Errorf("checksum verification failed: %s is missing in the tar", name)
+		}
+		if local[name] != sum {
+			return fmt.Errorf("checksum verification failed: %s has sha256 %s, the container reports %s", name, local[name], sum)
+		}
+	}
+	for name := range local {
+		if remote[name] == "" {
+			return fmt.Errorf("checksum verification failed: %s has no sha256 from the container", name)
+		}
+	}
+	return nil
+}
+
+func writeFetched(dest string, content io.Reader) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("create %s error: %v", dest, err)
+	}
+	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s error: %v", dest, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write %s error: %v", dest, err)
+	}
+	return nil
+}
+
+// uploadFetched PUTs the content to dest, any non 2xx status is an error. Errors leave out
+// the query of dest, the signature of a presigned url.
+func uploadFetched(ctx context.Context, dest string, content io.Reader, size int64) error {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return err
+	}
+	endpoint := u.Scheme + "://" + u.Host + u.Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, dest, ioutil.NopCloser(content))
+	if err != nil {
+		return fmt.Errorf("upload to %s error: %v", endpoint, err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("upload to %s error: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("upload to %s error: responded %s: %s", endpoint, resp.Status, strings.TrimSpace(cutUTF8(string(b), 512)))
+	}
+	return nil
+}
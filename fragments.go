@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// fragmentOverhead is an upper bound of the json around the data of a fragment line.
+const fragmentOverhead = 160
+
+var maxLineBytes = flag.Int("max-line-bytes", 0, "split output lines longer than this into fragments, e.g. 16000 below the 16KiB log line limit of container runtimes, 0 disables; k8s-cronjob reassemble joins them")
+
+// Fragment is one line of a split output line, Data is base64 of its part of the line.
+type Fragment struct {
+	Fragment FragmentInfo `json:"k8sCronjobFragment"`
+	Data     string       `json:"data"`
+}
+
+type FragmentInfo struct {
+	ID    string `json:"id"`
+	Seq   int    `json:"seq"`
+	Total int    `json:"total"`
+}
+
+// WriteLine writes b as one line, or as fragment lines when it is longer than -max-line-bytes.
+func WriteLine(w io.Writer, b []byte) error {
+	if *maxLineBytes <= 0 || len(b)+1 <= *maxLineBytes {
+		_, err := w.Write(append(b, '\n'))
+		return err
+	}
+	id, err := newRunID()
+	if err != nil {
+		return err
+	}
+	size := (*maxLineBytes - fragmentOverhead) / 4 * 3
+	total := (len(b) + size - 1) / size
+	for seq := 0; seq < total; seq++ {
+		end := (seq + 1) * size
+		if end > len(b) {
+			end = len(b)
+		}
+		line, _ := json.Marshal(&Fragment{
+			Fragment: FragmentInfo{
+				ID:    id,
+				Seq:   seq,
+				Total: total,
+			},
+			Data: base64.StdEncoding.EncodeToString(b[seq*size : end]),
+		})
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunReassemble copies stdin to stdout, joining fragment lines back into the original line.
+// Other lines pass unchanged, so the input can be a whole pod log.
+func RunReassemble() {
+	if err := Reassemble(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "reassemble error: %v\n", err)
+		os.Exit(-1)
+	}
+}
+
+func Reassemble(r io.Reader, w io.Writer) error {
+	pending := map[string]map[int][]byte{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var f Fragment
+		if err := json.Unmarshal(line, &f); err != nil || f.Fragment.ID == "" {
+			if _, err := w.Write(append(line, '\n')); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(f.Data)
+		if err != nil {
+			return fmt.Errorf("fragment %s/%d: %v", f.Fragment.ID, f.Fragment.Seq, err)
+		}
+		parts := pending[f.Fragment.ID]
+		if parts == nil {
+			parts = map[int][]byte{}
+			pending[f.Fragment.ID] = parts
+		}
+		parts[f.Fragment.Seq] = data
+		if len(parts) < f.Fragment.Total {
+			continue
+		}
+		seqs := make([]int, 0, len(parts))
+		for seq := range parts {
+			seqs = append(seqs, seq)
+		}
+		sort.Ints(seqs)
+		for _, seq := range seqs {
+			if _, err := w.Write(parts[seq]); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+		delete(pending, f.Fragment.ID)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for id, parts := range pending {
+		return fmt.Errorf("fragment %s incomplete, %d parts", id, len(parts))
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LabelManagedBy marks the leases and ConfigMaps k8s-cronjob creates, gc only looks at those.
+const (
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+	managedByValue = "k8s-cronjob"
+)
+
+var (
+	gcTTL      = flag.Duration("gc-ttl", 7*24*time.Hour, "gc: delete objects created by k8s-cronjob that were not used for this long")
+	gcDryRun   = flag.Bool("gc-dry-run", false, "gc: only report what would be deleted")
+	gcInterval = flag.Duration("gc-interval", 0, "with -listen-webhook, run gc over the target namespaces this often, 0 for never")
+)
+
+// managedLabels are set on every object k8s-cronjob creates.
+func managedLabels() map[string]string {
+	return map[string]string{
+		LabelManagedBy: managedByValue,
+	}
+}
+
+type GCObject struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	LastUsed  string `json:"lastUsed"`
+}
+
+// GCReport lists the objects gc deleted, or would delete with -gc-dry-run.
+type GCReport struct {
+	DryRun  bool        `json:"dryRun,omitempty"`
+	Deleted []*GCObject `json:"deleted"`
+	Kept    int         `json:"kept"`
+	Errors  []string    `json:"errors,omitempty"`
+}
+
+func validateGC(cfgErr *ConfigError) {
+	if *gcTTL <= 0 {
+		cfgErr.Add("gc-ttl", "must be positive")
+	}
+	if *gcInterval < 0 {
+		cfgErr.Add("gc-interval", "must not be negative")
+	}
+}
+
+// RunGC deletes the objects left behind in the -ns namespace, or the target namespaces of
+// -f, that were not used for -gc-ttl.
+func RunGC() {
+	cfgErr := &ConfigError{}
+	validateGC(cfgErr)
+	namespaces := []string{*namespace}
+	if *configFile != "" {
+		cfg, err := LoadConfig(*configFile)
+		if err != nil {
+			cfgErr.Add("f", "%v", err)
+		} else {
+			namespaces = configNamespaces(cfg)
+		}
+	}
+	namespaces = gcNamespaces(namespaces)
+	for _, ns := range namespaces {
+		if err := CheckNamespacePolicy(ns); err != nil {
+			cfgErr.Add("ns", "%v", err)
+		}
+	}
+	if err := cfgErr.ErrOrNil(); err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	_, clientset, err := NewClient()
+	if err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	report := CollectGarbage(context.Background(), clientset, namespaces, *gcTTL, *gcDryRun)
+	resp := &Response{
+		GC: report,
+	}
+	if len(report.Errors) > 0 {
+		resp.Error = fmt.Errorf("gc error: %s", strings.Join(report.Errors, "; "))
+		SendError(resp)
+	}
+	SendSuccess(resp)
+}
+
+// configNamespaces returns the distinct target namespaces of the config, sorted.
+func configNamespaces(cfg *Config) []string {
+	seen := map[string]bool{}
+	var namespaces []string
+	for _, t := range cfg.Targets {
+		if !seen[t.Namespace] {
+			seen[t.Namespace] = true
+			namespaces = append(namespaces, t.Namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// gcNamespaces adds the -global-semaphore-ns namespace to the namespaces.
+func gcNamespaces(namespaces []string) []string {
+	if *globalSemaphoreNS == "" {
+		return namespaces
+	}
+	for _, ns := range namespaces {
+		if ns == *globalSemaphoreNS {
+			return namespaces
+		}
+	}
+	return append(namespaces, *globalSemaphoreNS)
+}
+
+// CollectGarbage deletes the leases that are not held and the history ConfigMaps without a
+// record for longer than ttl. Deletes are conditional on the resource version, so an object
+// used meanwhile is kept.
+func CollectGarbage(ctx context.Context, clientset *kubernetes.Clientset, namespaces []string, ttl time.Duration, dryRun bool) *GCReport {
+	report := &GCReport{
+		DryRun:  dryRun,
+		Deleted: []*GCObject{},
+	}
+	opts := v1.ListOptions{
+		LabelSelector: LabelManagedBy + "=" + managedByValue,
+	}
+	for _, ns := range namespaces {
+		leases, err := clientset.CoordinationV1().Leases(ns).List(ctx, opts)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("list leases in %s error: %v", ns, err))
+		} else {
+			for i := range leases.Items {
+				lease := &leases.Items[i]
+				last := lease.CreationTimestamp.Time
+				if lease.Spec.RenewTime != nil {
+					last = lease.Spec.RenewTime.Time
+				}
+				if leaseHeld(lease) || time.Since(last) < ttl {
+					report.Kept++
+					continue
+				}
+				err := deleteUnchanged(ctx, dryRun, lease.ResourceVersion, func(ctx context.Context, opts v1.DeleteOptions) error {
+					return clientset.CoordinationV1().Leases(ns).Delete(ctx, lease.Name, opts)
+				})
+				report.add(err, "Lease", ns, lease.Name, last)
+			}
+		}
+		cms, err := clientset.CoreV1().ConfigMaps(ns).List(ctx, opts)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("list configmaps in %s error: %v", ns, err))
+			continue
+		}
+		for i := range cms.Items {
+			cm := &cms.Items[i]
+			last := cm.CreationTimestamp.Time
+			for key := range cm.Data {
+				if t, ok := historyKeyTime(key); strings.HasPrefix(key, historyKeyPrefix) && ok && t.After(last) {
+					last = t
+				}
+			}
+			if time.Since(last) < ttl {
+				report.Kept++
+				continue
+			}
+			err := deleteUnchanged(ctx, dryRun, cm.ResourceVersion, func(ctx context.Context, opts v1.DeleteOptions) error {
+				return clientset.CoreV1().ConfigMaps(ns).Delete(ctx, cm.Name, opts)
+			})
+			report.add(err, "ConfigMap", ns, cm.Name, last)
+		}
+	}
+	return report
+}
+
+func deleteUnchanged(ctx context.Context, dryRun bool, resourceVersion string, del func(context.Context, v1.DeleteOptions) error) error {
+	if dryRun {
+		return nil
+	}
+	return RetryAPI(ctx, func() error {
+		return del(ctx, v1.DeleteOptions{
+			Preconditions: &v1.Preconditions{
+				ResourceVersion: &resourceVersion,
+			},
+		})
+	})
+}
+
+func (r *GCReport) add(err error, kind string, namespace string, name string, last time.Time) {
+	switch {
+	case errors.IsNotFound(err):
+	case errors.IsConflict(err):
+		// used since it was listed.
+		r.Kept++
+	case err != nil:
+		r.Errors = append(r.Errors, fmt.Sprintf("delete %s %s/%s error: %v", strings.ToLower(kind), namespace, name, err))
+	default:
+		r.Deleted = append(r.Deleted, &GCObject{
+			Kind:      kind,
+			Namespace: namespace,
+			Name:      name,
+			LastUsed:  last.UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+// runGCLoop runs gc over the namespaces every -gc-interval until ctx is done, reporting the
+// sweeps that deleted something or failed.
+func runGCLoop(ctx context.Context, clientset *kubernetes.Clientset, namespaces []string) {
+	ticker := time.NewTicker(*gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		report := CollectGarbage(ctx, clientset, namespaces, *gcTTL, false)
+		if len(report.Deleted) == 0 && len(report.Errors) == 0 {
+			continue
+		}
+		resp := &Response{
+			GC: report,
+		}
+		if len(report.Errors) > 0 {
+			resp.Error = fmt.Errorf("gc error: %s", strings.Join(report.Errors, "; "))
+		}
+		SendResponse(resp)
+	}
+}
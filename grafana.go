@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	grafanaURL   = flag.String("grafana-url", "", "grafana base url, e.g. https://grafana.example.com, the run is annotated on dashboards from start to end")
+	grafanaToken = flag.String("grafana-token", "", "grafana service account token with annotation write access, better set through K8S_CRONJOB_GRAFANA_TOKEN")
+	grafanaTags  stringsFlag
+
+	// grafanaAnnotation is the id of the annotation of this run, 0 when none was created.
+	grafanaAnnotation int64
+	grafanaText       string
+)
+
+func init() {
+	flag.Var(&grafanaTags, "grafana-tag", "tag of the grafana annotation besides k8s-cronjob, the -label pairs and the status, repeatable")
+}
+
+func validateGrafana(cfgErr *ConfigError) {
+	if *grafanaURL == "" {
+		if *grafanaToken != "" || len(grafanaTags) > 0 {
+			cfgErr.Add("grafana-url", "is required with -grafana-token and -grafana-tag")
+		}
+		return
+	}
+	if u, err := url.Parse(*grafanaURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		cfgErr.Add("grafana-url", "must be an http or https url")
+	}
+}
+
+func grafanaRunTags() []string {
+	tags := append([]string{"k8s-cronjob"}, grafanaTags...)
+	var labels []string
+	for k, v := range RunLabels() {
+		labels = append(labels, k+":"+v)
+	}
+	sort.Strings(labels)
+	return append(tags, labels...)
+}
+
+// GrafanaStart creates the annotation of the run when -grafana-url is set, what describes the run.
+// Errors are reported but do not stop the run.
+func GrafanaStart(what string) {
+	if *grafanaURL == "" {
+		return
+	}
+	grafanaText = "k8s-cronjob " + what
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	err := grafanaRequest(http.MethodPost, "/api/annotations", map[string]interface{}{
+		"time": runStart.UnixNano() / int64(time.Millisecond),
+		"tags": grafanaRunTags(),
+		"text": grafanaText,
+	}, &created)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grafana annotation error: %v\n", err)
+		return
+	}
+	grafanaAnnotation = created.ID
+}
+
+// GrafanaEnd turns the annotation of the run into a region ending now, tagged with the status.
+func GrafanaEnd(resp *Response) {
+	if grafanaAnnotation == 0 {
+		return
+	}
+	text := fmt.Sprintf("%s: %s", grafanaText, resp.Status())
+	if resp.Pod != "" {
+		text += " in " + resp.Pod
+	}
+	if resp.Error != nil {
+		text += ", " + cutGrafanaText(resp.Error.Error())
+	}
+	err := grafanaRequest(http.MethodPatch, fmt.Sprintf("/api/annotations/%d", grafanaAnnotation), map[string]interface{}{
+		"timeEnd": time.Now().UnixNano() / int64(time.Millisecond),
+		"tags":    append(grafanaRunTags(), resp.Status()),
+		"text":    text,
+	}, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grafana annotation error: %v\n", err)
+	}
+}
+
+func cutGrafanaText(s string) string {
+	if len(s) > 200 {
+		return s[:197] + "..."
+	}
+	return s
+}
+
+func grafanaRequest(method string, path string, payload interface{}, out interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(*grafanaURL, "/")+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if *grafanaToken != "" {
+		req.Header.Set("Authorization", "Bearer "+*grafanaToken)
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return fmt.Errorf("grafana responded %s", resp.Status)
+	}
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const PodStatusSkippedUnhealthy = "skipped-unhealthy"
+
+var (
+	healthHTTP = flag.String("health-http", "", "skip the run unless this http check passes in the target container, e.g. 'GET :8080/healthz', checked with curl or wget in the container")
+	healthWait = flag.Duration("health-wait", 0, "keep checking -health-http this long before skipping the run")
+)
+
+// parseHealthHTTP splits "[METHOD] [host]:port/path" into the method and a url.
+func parseHealthHTTP(s string) (string, string, error) {
+	method, target := http.MethodGet, strings.TrimSpace(s)
+	if fields := strings.Fields(target); len(fields) == 2 {
+		method, target = strings.ToUpper(fields[0]), fields[1]
+	} else if len(fields) != 1 {
+		return "", "", fmt.Errorf("malformed health check %q, want e.g. GET :8080/healthz", s)
+	}
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		if strings.HasPrefix(target, ":") {
+			target = "localhost" + target
+		}
+		target = "http://" + target
+	}
+	return method, target, nil
+}
+
+// healthScript returns the shell script running the check, failing on a non 2xx status.
+func healthScript(method string, url string) string {
+	if *targetOS == OSWindows {
+		return fmt.Sprintf("curl.exe -fsS -X %s -o NUL %s", method, url)
+	}
+	url = shellQuote(url)
+	if method != http.MethodGet {
+		// wget, the fallback, only sends GET.
+		return fmt.Sprintf("curl -fsS -X %s -o /dev/null %s", method, url)
+	}
+	return fmt.Sprintf("if command -v curl >/dev/null; then curl -fsS -o /dev/null %s; else wget -q -O /dev/null %s; fi", url, url)
+}
+
+// CheckHealth runs -health-http in the target container until it passes or -health-wait elapsed.
+func CheckHealth(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, namespace string, podName string, containerName string) error {
+	method, url, err := parseHealthHTTP(*healthHTTP)
+	if err != nil {
+		return err
+	}
+	args, _ := ShellArgs(DefaultShell(), healthScript(method, url))
+	deadline := time.Now().Add(*healthWait)
+	for {
+		_, stderr, err := ExecInPod(ctx, clientset, config, namespace, podName, containerName, args)
+		if err == nil {
+			return nil
+		}
+		if stderr != "" {
+			err = fmt.Errorf("%s", stderr)
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%s %s: %v", method, url, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(*pollInterval):
+		}
+	}
+}
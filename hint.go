@@ -0,0 +1,60 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// errorHints map well-known failures, by a stderr line or the exit code, to their likely
+// cause. The first match wins.
+var errorHints = []struct {
+	pattern  *regexp.Regexp
+	exitCode int
+	hint     string
+}{
+	{
+		pattern:  regexp.MustCompile(`(?i)out of memory|cannot allocate memory|oomkilled|memoryerror|java\.lang\.OutOfMemoryError|^killed$`),
+		exitCode: 137,
+		hint:     "the command was killed, likely out of memory: raise the memory limit of the container or lower what the command needs, -sample-usage shows the usage",
+	},
+	{
+		pattern:  regexp.MustCompile(`(?i)command not found|executable file not found|no such file or directory: unknown|: not found$`),
+		exitCode: 127,
+		hint:     "the command is not installed in the image or not on PATH: check the image and that -cn picks the right container",
+	},
+	{
+		pattern:  regexp.MustCompile(`(?i)permission denied|operation not permitted|eacces|read-only file system`),
+		exitCode: 126,
+		hint:     "permission denied: check the user the container runs as, the file permissions and the securityContext, e.g. readOnlyRootFilesystem",
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)connection refused|econnrefused|could not connect to server|can't connect to`),
+		hint:    "a connection was refused: check that the service the command connects to is up and the host and port are right, e.g. with -action tcp-check",
+	},
+}
+
+// ErrorHint suggests the likely cause of a failed run from its stderr and exit code, empty
+// when nothing well-known matched. The error counts too when the command exited, it then
+// carries what the container runtime reported; api errors are not the command's.
+func ErrorHint(resp *Response) string {
+	if resp.Error == nil || resp.Cancelled {
+		return ""
+	}
+	text := resp.Stderr
+	code, exited := ExitCode(resp.Error)
+	if exited {
+		text += "\n" + resp.Error.Error()
+	}
+	lines := strings.Split(text, "\n")
+	for _, h := range errorHints {
+		for _, line := range lines {
+			if h.pattern.MatchString(strings.TrimSpace(line)) {
+				return h.hint
+			}
+		}
+		if exited && h.exitCode != 0 && code == h.exitCode {
+			return h.hint
+		}
+	}
+	return ""
+}
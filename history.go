@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	historyKeyPrefix = "run-"
+	// etcd rejects objects above 1MiB, keep some room for metadata.
+	historyMaxSize = 1000 * 1024
+	// outputs larger than this are stored as gzip+base64.
+	historyCompressThreshold = 4 * 1024
+	historyEncodingGzip      = "gzip+base64"
+)
+
+type HistoryRecord struct {
+	Start     time.Time         `json:"start"`
+	Duration  string            `json:"duration"`
+	Pod       string            `json:"pod"`
+	Command   []string          `json:"command"`
+	Stdout    string            `json:"stdout,omitempty"`
+	Stderr    string            `json:"stderr,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Cancelled bool              `json:"cancelled,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Runner    *RunnerInfo       `json:"runner,omitempty"`
+	Encoding  string            `json:"encoding,omitempty"`
+}
+
+func NewHistoryRecord(start time.Time, podName string, cmd []string, resp *Response) *HistoryRecord {
+	record := &HistoryRecord{
+		Start:     start.UTC(),
+		Duration:  time.Since(start).String(),
+		Pod:       podName,
+		Command:   RedactArgs(cmd),
+		Stdout:    resp.Stdout,
+		Stderr:    resp.Stderr,
+		Cancelled: resp.Cancelled,
+		Labels:    RunLabels(),
+		Runner:    runner,
+	}
+	if resp.Error != nil {
+		record.Error = resp.Error.Error()
+	}
+	return record
+}
+
+// Encode returns the json form of the record, compressing outputs when they are large.
+func (r *HistoryRecord) Encode() (string, error) {
+	if len(r.Stdout)+len(r.Stderr) > historyCompressThreshold {
+		stdout, err := compressString(r.Stdout)
+		if err != nil {
+			return "", err
+		}
+		stderr, err := compressString(r.Stderr)
+		if err != nil {
+			return "", err
+		}
+		compressed := *r
+		compressed.Stdout = stdout
+		compressed.Stderr = stderr
+		compressed.Encoding = historyEncodingGzip
+		r = &compressed
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func DecodeHistoryRecord(s string) (*HistoryRecord, error) {
+	record := &HistoryRecord{}
+	if err := json.Unmarshal([]byte(s), record); err != nil {
+		return nil, err
+	}
+	if record.Encoding == historyEncodingGzip {
+		stdout, err := decompressString(record.Stdout)
+		if err != nil {
+			return nil, err
+		}
+		stderr, err := decompressString(record.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		record.Stdout = stdout
+		record.Stderr = stderr
+		record.Encoding = ""
+	}
+	return record, nil
+}
+
+func compressString(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decompressString(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func historyKey(t time.Time) string {
+	return fmt.Sprintf("%s%020d", historyKeyPrefix, t.UnixNano())
+}
+
+func historyKeyTime(key string) (time.Time, bool) {
+	var nsec int64
+	if _, err := fmt.Sscanf(strings.TrimPrefix(key, historyKeyPrefix), "%d", &nsec); err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nsec), true
+}
+
+// RecordHistory appends the record to the history ConfigMap, then prunes old entries
+// by limit, ttl and total size.
+func RecordHistory(clientset *kubernetes.Clientset, namespace string, name string, record *HistoryRecord, limit int, ttl time.Duration) error {
+	value, err := record.Encode()
+	if err != nil {
+		return err
+	}
+	if len(value) > historyMaxSize {
+		// drop the outputs rather than failing to record the run at all.
+		truncated := *record
+		truncated.Stdout = ""
+		truncated.Stderr = ""
+		truncated.Error = fmt.Sprintf("%s (output dropped: %d bytes exceeds history size limit)", record.Error, len(value))
+		if value, err = truncated.Encode(); err != nil {
+			return err
+		}
+	}
+	key := historyKey(record.Start)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+		cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, v1.GetOptions{})
+		if errors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+					Labels:    managedLabels(),
+				},
+				Data: map[string]string{key: value},
+			}
+			_, err = clientset.CoreV1().ConfigMaps(namespace).Create(ctx, cm, v1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[key] = value
+		PruneHistory(cm.Data, limit, ttl)
+		_, err = clientset.CoreV1().ConfigMaps(namespace).Update(ctx, cm, v1.UpdateOptions{})
+		return err
+	})
+}
+
+// PruneHistory removes the oldest records until data respects limit, ttl and historyMaxSize.
+// limit <= 0 and ttl <= 0 disable the respective policy.
+func PruneHistory(data map[string]string, limit int, ttl time.Duration) {
+	keys := make([]string, 0, len(data))
+	size := 0
+	for k, v := range data {
+		if !strings.HasPrefix(k, historyKeyPrefix) {
+			continue
+		}
+		keys = append(keys, k)
+		size += len(k) + len(v)
+	}
+	sort.Strings(keys)
+	for len(keys) > 0 {
+		oldest := keys[0]
+		expired := false
+		if ttl > 0 {
+			if t, ok := historyKeyTime(oldest); ok && time.Since(t) > ttl {
+				expired = true
+			}
+		}
+		if !expired && (limit <= 0 || len(keys) <= limit) && size <= historyMaxSize {
+			return
+		}
+		size -= len(oldest) + len(data[oldest])
+		delete(data, oldest)
+		keys = keys[1:]
+	}
+}
+
+// minSlowSamples is the number of earlier successful runs needed before a run can be slow.
+const minSlowSamples = 3
+
+// HistoryDurations returns the durations of the recorded successful runs of cmd, oldest first.
+func HistoryDurations(clientset *kubernetes.Clientset, namespace string, name string, cmd []string) ([]time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	var cm *corev1.ConfigMap
+	err := RetryAPI(ctx, func() (err error) {
+		cm, err = clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, v1.GetOptions{})
+		return err
+	})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(cm.Data))
+	for k := range cm.Data {
+		if strings.HasPrefix(k, historyKeyPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	// records keep the command redacted.
+	want := strings.Join(RedactArgs(cmd), "\x00")
+	var durations []time.Duration
+	for _, k := range keys {
+		// outputs are not needed, skip decompressing them.
+		record := &HistoryRecord{}
+		if err := json.Unmarshal([]byte(cm.Data[k]), record); err != nil {
+			continue
+		}
+		if record.Error != "" || strings.Join(record.Command, "\x00") != want {
+			continue
+		}
+		if d, err := time.ParseDuration(record.Duration); err == nil {
+			durations = append(durations, d)
+		}
+	}
+	return durations, nil
+}
+
+// minTimeoutSamples is the number of earlier successful runs needed to learn a timeout.
+const minTimeoutSamples = 10
+
+// learnedTimeout is the timeout learned for the single run of the process, -listen-webhook
+// runs keep theirs in Response.LearnedTimeout.
+var learnedTimeout time.Duration
+
+func validateLearnedTimeout(cfgErr *ConfigError) {
+	if *timeoutFactor != 0 && *timeoutFactor < 1 {
+		cfgErr.Add("timeout-factor", "must be 0 or at least 1")
+	}
+	if *timeoutFloor <= 0 {
+		cfgErr.Add("timeout-floor", "must be positive")
+	}
+}
+
+// LearnedTimeout returns max(p95 * -timeout-factor, -timeout-floor) of the recorded successful
+// runs of cmd. It is 0, so -exec-timeout applies, when -exec-timeout is set, there is no
+// -timeout-factor or history, or fewer than minTimeoutSamples runs were recorded.
+func LearnedTimeout(clientset *kubernetes.Clientset, namespace string, name string, cmd []string) time.Duration {
+	if *timeoutFactor <= 0 || *execTimeout > 0 || name == "" {
+		return 0
+	}
+	durations, err := HistoryDurations(clientset, namespace, name, cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read history error: %v\n", err)
+		return 0
+	}
+	if len(durations) < minTimeoutSamples {
+		return 0
+	}
+	timeout := time.Duration(float64(PercentileDuration(durations, 95)) * *timeoutFactor)
+	if timeout < *timeoutFloor {
+		timeout = *timeoutFloor
+	}
+	return timeout.Round(time.Second)
+}
+
+// PercentileDuration returns the nearest-rank percentile p of durations, which must not be empty.
+func PercentileDuration(durations []time.Duration, p int) time.Duration {
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// MedianDuration returns the median of durations, which must not be empty.
+func MedianDuration(durations []time.Duration) time.Duration {
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
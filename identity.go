@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const PodStatusTargetChurned = "target-churned"
+
+var chaosSafe = flag.Bool("chaos-safe", false, "record the uid of the picked pod and check right before the exec and after the command that the same pod still exists and runs, the run is target-churned otherwise")
+
+// PodUID returns the uid of the running pod.
+func PodUID(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string) (types.UID, error) {
+	pod, err := getPod(ctx, clientset, namespace, podName)
+	if err != nil {
+		return "", err
+	}
+	return pod.UID, nil
+}
+
+// PodChange describes how the pod with uid changed, empty when it still exists and runs.
+// err is set when the pod could not be read.
+func PodChange(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string, uid types.UID) (string, error) {
+	pod, err := getPod(ctx, clientset, namespace, podName)
+	if errors.IsNotFound(err) {
+		return fmt.Sprintf("pod %s is gone", podName), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if pod.UID != uid {
+		return fmt.Sprintf("pod %s was recreated, uid %s is now %s", podName, uid, pod.UID), nil
+	}
+	if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+		return fmt.Sprintf("pod %s is no longer running", podName), nil
+	}
+	return "", nil
+}
+
+func getPod(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string) (*corev1.Pod, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+	var pod *corev1.Pod
+	err := RetryAPI(ctx, func() (err error) {
+		pod, err = clientset.CoreV1().Pods(namespace).Get(ctx, podName, v1.GetOptions{})
+		return err
+	})
+	return pod, err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+)
+
+var preferIdle = flag.Bool("prefer-idle", false, "pick the eligible pod with the lowest cpu, then memory, usage reported by metrics-server")
+
+// podMetricsList is the part of a metrics.k8s.io/v1beta1 PodMetricsList used here.
+type podMetricsList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Containers []struct {
+			Usage map[corev1.ResourceName]resource.Quantity `json:"usage"`
+		} `json:"containers"`
+	} `json:"items"`
+}
+
+type podUsage struct {
+	cpu    int64
+	memory int64
+}
+
+// PodUsage returns the cpu (millicores) and memory (bytes) usage of the pods in namespace.
+func PodUsage(ctx context.Context, clientset *kubernetes.Clientset, namespace string, labels string) (map[string]podUsage, error) {
+	var body []byte
+	err := RetryAPI(ctx, func() (err error) {
+		ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+		defer cancel()
+		req := clientset.CoreV1().RESTClient().Get().AbsPath("/apis/metrics.k8s.io/v1beta1", "namespaces", namespace, "pods")
+		if labels != "" {
+			req = req.Param("labelSelector", labels)
+		}
+		body, err = req.DoRaw(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := &podMetricsList{}
+	if err := json.Unmarshal(body, list); err != nil {
+		return nil, fmt.Errorf("parse pod metrics error: %v", err)
+	}
+	usage := map[string]podUsage{}
+	for _, item := range list.Items {
+		u := podUsage{}
+		for _, c := range item.Containers {
+			cpu := c.Usage[corev1.ResourceCPU]
+			memory := c.Usage[corev1.ResourceMemory]
+			u.cpu += cpu.MilliValue()
+			u.memory += memory.Value()
+		}
+		usage[item.Metadata.Name] = u
+	}
+	return usage, nil
+}
+
+// PickIdlePod picks the eligible pod using the least cpu, then memory. Pods without metrics
+// rank last, without any metrics PickPod decides.
+func PickIdlePod(ctx context.Context, clientset *kubernetes.Clientset, namespace string, labels string, pods []corev1.Pod) *corev1.Pod {
+	usage, err := PodUsage(ctx, clientset, namespace, labels)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read pod metrics error: %v\n", err)
+		return PickPod(pods)
+	}
+	var picked *corev1.Pod
+	var pickedUsage podUsage
+	for i := range pods {
+		if !IsEligiblePod(&pods[i]) {
+			continue
+		}
+		u, ok := usage[pods[i].Name]
+		if !ok {
+			continue
+		}
+		if picked == nil || u.cpu < pickedUsage.cpu || (u.cpu == pickedUsage.cpu && u.memory < pickedUsage.memory) {
+			picked, pickedUsage = &pods[i], u
+		}
+	}
+	if picked == nil {
+		return PickPod(pods)
+	}
+	return picked
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+func validateExpectImage(cfgErr *ConfigError, field string, pattern string) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		cfgErr.Add(field, "malformed image pattern: %v", err)
+	}
+}
+
+// ImageMatches matches the image against the pattern, a pattern without / also matches the
+// image without its registry and repository path, so myapp:1.4.* matches
+// registry.example.com/team/myapp:1.4.2.
+func ImageMatches(pattern string, image string) bool {
+	if ok, _ := path.Match(pattern, image); ok {
+		return true
+	}
+	if strings.Contains(pattern, "/") {
+		return false
+	}
+	ok, _ := path.Match(pattern, image[strings.LastIndex(image, "/")+1:])
+	return ok
+}
+
+// CheckImage refuses to run when the container the command runs in does not run an image
+// matching the pattern. Without a container name that is the default container of the pod.
+func CheckImage(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string, containerName string, pattern string) error {
+	pod, err := getPod(ctx, clientset, namespace, podName)
+	if err != nil {
+		return fmt.Errorf("get pod error: %v", err)
+	}
+	c := execContainer(pod, containerName)
+	if c == nil {
+		return fmt.Errorf("pod %s has no container %s", podName, containerName)
+	}
+	if !ImageMatches(pattern, c.Image) {
+		return fmt.Errorf("container %s runs image %s, not %s", c.Name, c.Image, pattern)
+	}
+	return nil
+}
+
+// execContainer returns the container an exec without a container name runs in, like kubectl.
+func execContainer(pod *corev1.Pod, containerName string) *corev1.Container {
+	if containerName == "" {
+		containerName = pod.Annotations[defaultContainerAnnotation]
+	}
+	for i := range pod.Spec.Containers {
+		if containerName == "" || pod.Spec.Containers[i].Name == containerName {
+			return &pod.Spec.Containers[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var jobsFile = flag.String("jobs", "", "manifest of independent jobs, runs every job that is due, at most -parallel at a time")
+
+// JobsManifest is the content of the -jobs file.
+type JobsManifest struct {
+	Jobs []Job `json:"jobs"`
+}
+
+// Job is a target run on its own, with the fields of a config file target.
+type Job struct {
+	Target `json:",inline"`
+	// Window is the HH:MM-HH:MM time of day the job is due in, it may wrap midnight.
+	// Empty is always due.
+	Window string `json:"window,omitempty"`
+	// Timezone of Window, default UTC.
+	Timezone string `json:"timezone,omitempty"`
+	// Retries is how often a failed run is repeated.
+	Retries int `json:"retries,omitempty"`
+}
+
+type JobResult struct {
+	Name     string                 `json:"name"`
+	Status   string                 `json:"status"`
+	Attempts int                    `json:"attempts,omitempty"`
+	Duration string                 `json:"duration,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	Result   map[string]interface{} `json:"result,omitempty"`
+}
+
+// JobsReport summarizes a -jobs run, jobs that are not due are skipped.
+type JobsReport struct {
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Skipped   int          `json:"skipped"`
+	Duration  string       `json:"duration"`
+	Table     string       `json:"table"`
+	Jobs      []*JobResult `json:"jobs"`
+}
+
+func LoadJobs(path string) (*JobsManifest, error) {
+	manifest := &JobsManifest{}
+	if err := loadTemplate(path, manifest); err != nil {
+		return nil, err
+	}
+	for i := range manifest.Jobs {
+		if manifest.Jobs[i].Namespace == "" {
+			manifest.Jobs[i].Namespace = "default"
+		}
+	}
+	return manifest, nil
+}
+
+func ValidateJobs(manifest *JobsManifest) error {
+	cfgErr := &ConfigError{}
+	if len(manifest.Jobs) == 0 {
+		cfgErr.Add("jobs", "no jobs")
+	}
+	names := map[string]bool{}
+	for i, job := range manifest.Jobs {
+		field := fmt.Sprintf("jobs: jobs[%d]", i)
+		if job.Name == "" {
+			cfgErr.Add(field, "name is required")
+		} else if names[job.Name] {
+			cfgErr.Add(field, "duplicate name %q", job.Name)
+		}
+		names[job.Name] = true
+		validateTarget(cfgErr, field, &job.Target)
+		if len(job.Command) == 0 && len(job.Commands) > 0 {
+			cfgErr.Add(field, "commands only apply to -f targets, set command")
+		}
+		if job.OnSuccess != "" || job.StdinFromPrevious {
+			cfgErr.Add(field, "onSuccess and stdinFromPrevious only apply to -f targets")
+		}
+		if job.Retries < 0 {
+			cfgErr.Add(field, "retries must not be negative")
+		}
+		if _, err := job.Due(time.Now()); err != nil {
+			cfgErr.Add(field, "%v", err)
+		}
+	}
+	validatePodSelection(cfgErr)
+	return cfgErr.ErrOrNil()
+}
+
+// Due reports whether now is inside the window of the job.
+func (j *Job) Due(now time.Time) (bool, error) {
+	if j.Window == "" {
+		return true, nil
+	}
+	loc := time.UTC
+	if j.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(j.Timezone); err != nil {
+			return false, fmt.Errorf("malformed timezone: %v", err)
+		}
+	}
+	parts := strings.Split(j.Window, "-")
+	if len(parts) != 2 {
+		return false, fmt.Errorf("malformed window %q, want HH:MM-HH:MM", j.Window)
+	}
+	from, err := time.Parse("15:04", strings.TrimSpace(parts[0]))
+	if err != nil {
+		return false, fmt.Errorf("malformed window %q: %v", j.Window, err)
+	}
+	to, err := time.Parse("15:04", strings.TrimSpace(parts[1]))
+	if err != nil {
+		return false, fmt.Errorf("malformed window %q: %v", j.Window, err)
+	}
+	now = now.In(loc)
+	minute := now.Hour()*60 + now.Minute()
+	start, end := from.Hour()*60+from.Minute(), to.Hour()*60+to.Minute()
+	if start <= end {
+		return minute >= start && minute < end, nil
+	}
+	return minute >= start || minute < end, nil
+}
+
+// RunJobs runs the due jobs of the -jobs manifest.
+func RunJobs(cmd []string) {
+	cfgErr := &ConfigError{}
+	if len(cmd) > 0 {
+		cfgErr.Add("jobs", "the commands come from the jobs manifest, do not pass one")
+		SendError(&Response{
+			Error: cfgErr,
+		})
+	}
+	manifest, err := LoadJobs(*jobsFile)
+	if err != nil {
+		cfgErr.Add("jobs", "%v", err)
+		SendError(&Response{
+			Error: cfgErr,
+		})
+	}
+	if err := ValidateJobs(manifest); err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	CompilePodSelection()
+	config, clientset, err := NewClient()
+	if err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	ctx, cancel := ExecContext(clientset, 0)
+	defer cancel()
+	GrafanaStart("jobs " + *jobsFile)
+	report := RunJobsManifest(ctx, clientset, config, manifest)
+	resp := &Response{
+		Jobs: report,
+	}
+	if report.Failed > 0 {
+		resp.Error = fmt.Errorf("%d of %d jobs failed", report.Failed, report.Succeeded+report.Failed)
+	}
+	finish(clientset, resp)
+}
+
+// RunJobsManifest runs every due job, at most -parallel at a time.
+func RunJobsManifest(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, manifest *JobsManifest) *JobsReport {
+	start := time.Now()
+	report := &JobsReport{
+		Jobs: make([]*JobResult, len(manifest.Jobs)),
+	}
+	sem := make(chan struct{}, *parallel)
+	var wg sync.WaitGroup
+	for i := range manifest.Jobs {
+		job := manifest.Jobs[i]
+		if due, _ := job.Due(start); !due {
+			report.Jobs[i] = &JobResult{
+				Name:   job.Name,
+				Status: PodStatusSkipped,
+				Error:  fmt.Sprintf("not due, window is %s", job.Window),
+			}
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			report.Jobs[i] = runJob(ctx, clientset, config, &job)
+		}(i)
+	}
+	wg.Wait()
+	for _, result := range report.Jobs {
+		switch result.Status {
+		case PodStatusSucceeded:
+			report.Succeeded++
+		case PodStatusFailed:
+			report.Failed++
+		default:
+			report.Skipped++
+		}
+	}
+	report.Duration = time.Since(start).String()
+	report.Table = report.FormatTable()
+	return report
+}
+
+func runJob(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, job *Job) *JobResult {
+	start := time.Now()
+	result := &JobResult{
+		Name: job.Name,
+	}
+	resp := SkipRun(ctx, clientset, &job.Target)
+	if resp == nil {
+		// retries belong to the same run, the cooldown is claimed once.
+		for result.Attempts <= job.Retries {
+			if result.Attempts > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(*pollInterval):
+				}
+			}
+			result.Attempts++
+			target := job.Target
+			resp = runChainStep(ctx, clientset, config, &target, nil, nil)
+			if resp.Error == nil || ctx.Err() != nil {
+				break
+			}
+		}
+		if resp.Error != nil {
+			ReleaseCooldown(clientset, &job.Target)
+		}
+	}
+	result.Duration = time.Since(start).String()
+	result.Result = BuildReply(resp)
+	result.Status = PodStatusSucceeded
+	if resp.Paused {
+		result.Status = PodStatusPaused
+		result.Error = resp.SkipReason
+	} else if resp.Cooldown {
+		result.Status = PodStatusSkippedCooldown
+		result.Error = resp.SkipReason
+	} else if resp.SkipReason != "" {
+		result.Status = PodStatusSkipped
+		result.Error = resp.SkipReason
+	} else if resp.Error != nil {
+		result.Status = PodStatusFailed
+		result.Error = resp.Error.Error()
+	}
+	return result
+}
+
+// FormatTable renders one line per job like BatchReport.FormatTable.
+func (r *JobsReport) FormatTable() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tSTATUS\tATTEMPTS\tDURATION\tERROR")
+	for _, result := range r.Jobs {
+		msg := strings.ReplaceAll(result.Error, "\n", " ")
+		if len(msg) > 80 {
+			msg = msg[:77] + "..."
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", result.Name, result.Status, result.Attempts, result.Duration, msg)
+	}
+	w.Flush()
+	return b.String()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	jobName        = flag.String("job", "", "name of a job in -ns, run the command in its running pod, e.g. to chain onto a batch workload")
+	allowCompleted = flag.Bool("allow-completed", false, "with -job, return the logs of its completed pod instead when no pod runs")
+)
+
+func validateJobTarget(cfgErr *ConfigError) {
+	if *jobName == "" {
+		if *allowCompleted {
+			cfgErr.Add("allow-completed", "requires -job")
+		}
+		return
+	}
+	if *allPods {
+		cfgErr.Add("job", "conflicts with -all")
+	}
+}
+
+// RunJobTarget runs the command in a running pod of -job, waiting for one up to -wp. With
+// -allow-completed the logs of a succeeded pod are returned as stdout once no pod runs.
+func RunJobTarget(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, cmd []string) *Response {
+	target := FlagsTarget(cmd)
+	target.Name = "job/" + *jobName
+	var job *batchv1.Job
+	err := RetryAPI(ctx, func() (err error) {
+		ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+		defer cancel()
+		job, err = clientset.BatchV1().Jobs(target.Namespace).Get(ctx, *jobName, v1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return &Response{
+			Target: target.Name,
+			Error:  fmt.Errorf("get job error: %v", err),
+		}
+	}
+	target.Labels = joinLabelSelectors(v1.FormatLabelSelector(job.Spec.Selector), target.Labels)
+	if !*allowCompleted {
+		resp := SelectAndRun(ctx, clientset, config, &target, nil)
+		resp.Target = target.Name
+		return resp
+	}
+	start := time.Now()
+	for {
+		pods, err := ListCandidatePods(ctx, clientset, target.Namespace, target.Labels, target.FieldSelector(), "")
+		if err != nil {
+			return &Response{
+				Target: target.Name,
+				Error:  fmt.Errorf("list pods error: %v", err),
+			}
+		}
+		if PickPod(pods) != nil {
+			resp := SelectAndRun(ctx, clientset, config, &target, nil)
+			resp.Target = target.Name
+			return resp
+		}
+		for _, pod := range pods {
+			if pod.Status.Phase == corev1.PodSucceeded {
+				return completedPodLogs(ctx, clientset, &target, pod.Name, start)
+			}
+		}
+		if time.Since(start) >= *waitRunningPodTimeout {
+			return &Response{
+				Target:      target.Name,
+				Error:       fmt.Errorf("lookup running pod error: no running or completed pod of %s", target.Name),
+				Diagnostics: DiagnoseLookup(clientset, target.Namespace, target.Labels, target.FieldSelector(), ""),
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return &Response{
+				Target:    target.Name,
+				Error:     ctx.Err(),
+				Cancelled: true,
+			}
+		case <-time.After(*pollInterval):
+		}
+	}
+}
+
+func completedPodLogs(ctx context.Context, clientset *kubernetes.Clientset, target *Target, podName string, start time.Time) *Response {
+	EmitEvent(EventPodSelected, map[string]interface{}{
+		"target":    target.Name,
+		"namespace": target.Namespace,
+		"pod":       podName,
+	})
+	logs, err := PodLogs(ctx, clientset, target.Namespace, podName, &corev1.PodLogOptions{
+		Container: target.Container,
+	})
+	resp := &Response{
+		Target: target.Name,
+		Pod:    podName,
+		Stdout: logs,
+		Error:  err,
+	}
+	RedactResponse(resp)
+	resp.Duration = time.Since(start).String()
+	return resp
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+var (
+	execPingPeriod = flag.Duration("exec-ping-period", 5*time.Second, "interval of keepalive pings on the exec connection, lower it below the idle timeout of load balancers in between, 0 disables pings")
+	idempotent     = flag.Bool("idempotent", false, "the command is safe to run again, an exec stream dropped by a connection error is started over up to -exec-reconnects times")
+	execReconnects = flag.Int("exec-reconnects", 3, "max restarts of an -idempotent command whose exec stream was dropped")
+)
+
+// newSPDYExecutor is remotecommand.NewSPDYExecutor with the ping period of -exec-ping-period.
+func newSPDYExecutor(config *rest.Config, method string, u *url.URL) (remotecommand.Executor, error) {
+	tlsConfig, err := rest.TLSConfigFor(config)
+	if err != nil {
+		return nil, err
+	}
+	proxy := http.ProxyFromEnvironment
+	if config.Proxy != nil {
+		proxy = config.Proxy
+	}
+	upgrader := spdy.NewRoundTripperWithConfig(spdy.RoundTripperConfig{
+		TLS:             tlsConfig,
+		FollowRedirects: true,
+		Proxier:         proxy,
+		PingPeriod:      *execPingPeriod,
+	})
+	wrapper, err := rest.HTTPWrappersForConfig(config, upgrader)
+	if err != nil {
+		return nil, err
+	}
+	return remotecommand.NewSPDYExecutorForTransports(wrapper, upgrader, method, u)
+}
+
+// isStreamDropped reports whether err ended the exec stream without the command exiting
+// or the api server refusing it.
+func isStreamDropped(err error) bool {
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return false
+	}
+	var status apierrors.APIStatus
+	return !errors.As(err, &status)
+}
+
+// restartStream prepares the output writers for running the command again, the output of
+// the dropped stream is discarded.
+func restartStream(err error, stdout *countingWriter, stderr *countingWriter) bool {
+	if stdout.Reset() != nil || stderr.Reset() != nil {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "exec stream dropped, running the command again: %v\n", err)
+	return true
+}
+
+// resetter is a writer that can drop what was written to it, for a restart of the stream.
+// Every writer wrapping the exec output implements it by resetting the writer it wraps.
+type resetter interface {
+	Reset() error
+}
+
+// resetWriter empties w, a resetter or a file.
+func resetWriter(w io.Writer) error {
+	switch w := w.(type) {
+	case resetter:
+		return w.Reset()
+	case *os.File:
+		if err := w.Truncate(0); err != nil {
+			return err
+		}
+		_, err := w.Seek(0, io.SeekStart)
+		return err
+	}
+	return fmt.Errorf("can not reset %T", w)
+}
+
+// Reset empties the underlying writer and starts counting over.
+func (c *countingWriter) Reset() error {
+	if err := resetWriter(c.w); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&c.n, 0)
+	return nil
+}
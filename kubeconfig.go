@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	// oidc auth-provider entries of a kubeconfig, exec credential plugins
+	// (aws eks get-token, gke-gcloud-auth-plugin, kubelogin) need no import.
+	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+)
+
+var (
+	kubeconfig  = flag.String("kubeconfig", "", "kubeconfig used outside a cluster, default $KUBECONFIG or ~/.kube/config")
+	kubeContext = flag.String("context", "", "kubeconfig context, default the current context")
+
+	requestTimeout        = flag.Duration("request-timeout", 0, "timeout of each api request, not of the exec stream, 0 for none")
+	userAgent             = flag.String("user-agent", "", "User-Agent of the api requests, shown in the audit log, default the client-go one")
+	insecureSkipTLSVerify = flag.Bool("insecure-skip-tls-verify", false, "do not verify the api server certificate, only for lab clusters")
+	certificateAuthority  = flag.String("certificate-authority", "", "CA bundle file verifying the api server certificate instead of the one of the cluster config")
+)
+
+// LoadRESTConfig returns the in-cluster config, or the kubeconfig one when running outside
+// a cluster or -kubeconfig/-context is set. Credentials of exec plugins and oidc are
+// refreshed by client-go as they expire, also during long runs.
+func LoadRESTConfig() (*rest.Config, error) {
+	if *kubeconfig == "" && *kubeContext == "" {
+		config, err := rest.InClusterConfig()
+		if err == nil {
+			return config, nil
+		}
+		if err != rest.ErrNotInCluster {
+			return nil, fmt.Errorf("load cluster config error: %v", err)
+		}
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = *kubeconfig
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{
+		CurrentContext: *kubeContext,
+	}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("load kubeconfig error: %v", err)
+	}
+	return config, nil
+}
+
+// ApplyClientOptions applies -request-timeout, -user-agent, -insecure-skip-tls-verify and
+// -certificate-authority to config.
+func ApplyClientOptions(config *rest.Config) error {
+	cfgErr := &ConfigError{}
+	if *requestTimeout < 0 {
+		cfgErr.Add("request-timeout", "must not be negative")
+	}
+	if *insecureSkipTLSVerify && *certificateAuthority != "" {
+		cfgErr.Add("insecure-skip-tls-verify", "conflicts with -certificate-authority")
+	}
+	if *certificateAuthority != "" {
+		if _, err := os.Stat(*certificateAuthority); err != nil {
+			cfgErr.Add("certificate-authority", "%v", err)
+		}
+	}
+	if err := cfgErr.ErrOrNil(); err != nil {
+		return err
+	}
+	if *requestTimeout > 0 {
+		config.Timeout = *requestTimeout
+	}
+	if *userAgent != "" {
+		config.UserAgent = *userAgent
+	}
+	if *insecureSkipTLSVerify {
+		// client-go refuses a CA together with insecure.
+		config.Insecure = true
+		config.CAFile = ""
+		config.CAData = nil
+	}
+	if *certificateAuthority != "" {
+		config.CAFile = *certificateAuthority
+		config.CAData = nil
+	}
+	return nil
+}
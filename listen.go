@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+var (
+	listenWebhook = flag.String("listen-webhook", "", "address like :9000 to keep serving POST /hooks/<source>, events run the -f targets their triggers match")
+	hmacSecret    = flag.String("hmac-secret", "", "secret -listen-webhook events are signed with, a sha256 hmac of the body in X-Hub-Signature-256 (sha256=<hex>) or X-Signature-256, or sent as bearer token, better set through K8S_CRONJOB_HMAC_SECRET")
+	webhookRate   = flag.Int("webhook-rate", 10, "events per minute -listen-webhook accepts from each source, 0 for unlimited")
+)
+
+// maxEventBytes bounds the body of an event.
+const maxEventBytes = 1 << 20
+
+// Trigger maps the events of a source to a run of a target of the config file.
+type Trigger struct {
+	// Source is the last path element events are posted to, /hooks/<source>.
+	Source string `json:"source"`
+	// Match requires fields of the json event, dotted paths like alerts.0.status, to have these values.
+	Match map[string]string `json:"match,omitempty"`
+	// Target is run with the targets following it, Command names one of its commands to run instead.
+	Target  string `json:"target"`
+	Command string `json:"command,omitempty"`
+}
+
+func validateTriggers(cfgErr *ConfigError, cfg *Config) {
+	for i, t := range cfg.Triggers {
+		field := fmt.Sprintf("f: triggers[%d]", i)
+		if t.Source == "" || strings.Contains(t.Source, "/") {
+			cfgErr.Add(field, "source must be a non empty path element")
+		}
+		target := cfg.Target(t.Target)
+		if target == nil {
+			cfgErr.Add(field, "unknown target %q", t.Target)
+			continue
+		}
+		if t.Command != "" {
+			if _, ok := target.Commands[t.Command]; !ok {
+				cfgErr.Add(field, "target %q has no command %q", t.Target, t.Command)
+			}
+		} else if len(target.Command) == 0 {
+			cfgErr.Add(field, "target %q has only named commands, pick one with command", t.Target)
+		}
+	}
+}
+
+// Matches reports whether the event has every field of Match.
+func (t *Trigger) Matches(event interface{}) bool {
+	for path, want := range t.Match {
+		v, ok := eventField(event, path)
+		if !ok || fmt.Sprint(v) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// eventField looks up a dotted path in decoded json, numbers index arrays.
+func eventField(v interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, v != nil
+}
+
+type webhookReceiver struct {
+	ctx       context.Context
+	cfg       *Config
+	config    *rest.Config
+	clientset *kubernetes.Clientset
+	limiters  map[string]flowcontrol.RateLimiter
+	limits    remediationLimits
+	runs      sync.WaitGroup
+	// targetsMu guards targets, the locks that run one chain per first target at a time: runs
+	// of the same target share its history, -cooldown lease and pods.
+	targetsMu sync.Mutex
+	targets   map[string]*sync.Mutex
+	// outputMu keeps the result lines of concurrent runs apart.
+	outputMu sync.Mutex
+}
+
+// RunWebhookReceiver serves -listen-webhook until SIGTERM, running the targets of the -f
+// config file whose triggers match the posted events. Running targets finish before it exits.
+func RunWebhookReceiver(cmd []string) {
+	cfgErr := &ConfigError{}
+	if len(cmd) > 0 {
+		cfgErr.Add("listen-webhook", "the commands come from the config file, do not pass one")
+	}
+	if *configFile == "" {
+		cfgErr.Add("listen-webhook", "requires -f with triggers")
+	}
+	if *hmacSecret == "" {
+		cfgErr.Add("hmac-secret", "is required with -listen-webhook")
+	}
+	if *webhookRate < 0 {
+		cfgErr.Add("webhook-rate", "must not be negative")
+	}
+	validateGC(cfgErr)
+	if err := cfgErr.ErrOrNil(); err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	cfg, err := LoadConfig(*configFile)
+	if err != nil {
+		cfgErr.Add("f", "%v", err)
+		SendError(&Response{
+			Error: cfgErr,
+		})
+	}
+	if err := ValidateConfig(cfg); err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	if len(cfg.Triggers) == 0 && len(cfg.Remediations) == 0 {
+		cfgErr.Add("f", "no triggers or remediations")
+		SendError(&Response{
+			Error: cfgErr,
+		})
+	}
+	CompilePodSelection()
+	config, clientset, err := NewClient()
+	if err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	r := &webhookReceiver{
+		ctx:       ctx,
+		cfg:       cfg,
+		config:    config,
+		clientset: clientset,
+		limiters:  map[string]flowcontrol.RateLimiter{},
+		targets:   map[string]*sync.Mutex{},
+		limits: remediationLimits{
+			last:    map[string]time.Time{},
+			perHour: map[int][]time.Time{},
+		},
+	}
+	sources := []string{}
+	for _, t := range cfg.Triggers {
+		sources = append(sources, t.Source)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/hooks/", r)
+	if len(cfg.Remediations) > 0 {
+		sources = append(sources, alertmanagerSource)
+		mux.HandleFunc("/alertmanager", r.serveAlertmanager)
+	}
+	for _, source := range sources {
+		if *webhookRate > 0 {
+			r.limiters[source] = flowcontrol.NewTokenBucketRateLimiter(float32(*webhookRate)/60, *webhookRate)
+		} else {
+			r.limiters[source] = flowcontrol.NewFakeAlwaysRateLimiter()
+		}
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	server := &http.Server{
+		Addr:    *listenWebhook,
+		Handler: mux,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	if *gcInterval > 0 {
+		go runGCLoop(ctx, clientset, gcNamespaces(configNamespaces(cfg)))
+	}
+	fmt.Fprintf(os.Stderr, "listening for events on %s\n", *listenWebhook)
+	select {
+	case err := <-serveErr:
+		SendError(&Response{
+			Error: fmt.Errorf("listen webhook error: %v", err),
+		})
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+	r.runs.Wait()
+	QuitMesh()
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	source := strings.TrimPrefix(req.URL.Path, "/hooks/")
+	body, ok := r.readEvent(w, req, source)
+	if !ok {
+		return
+	}
+	var event interface{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "event is not json", http.StatusBadRequest)
+		return
+	}
+	runs := []string{}
+	for i := range r.cfg.Triggers {
+		t := &r.cfg.Triggers[i]
+		if t.Source == source && t.Matches(event) {
+			r.start(t)
+			runs = append(runs, t.Target)
+		}
+	}
+	writeRuns(w, map[string]interface{}{
+		"runs": runs,
+	}, len(runs) > 0)
+}
+
+// readEvent checks the method, size, signature and rate limit of the source and returns the
+// body. It answers the request itself when the event is rejected.
+func (r *webhookReceiver) readEvent(w http.ResponseWriter, req *http.Request, source string) ([]byte, bool) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is accepted", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	limiter, ok := r.limiters[source]
+	if !ok {
+		http.Error(w, "unknown source", http.StatusNotFound)
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxEventBytes+1))
+	if err != nil {
+		http.Error(w, "read event error", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(body) > maxEventBytes {
+		http.Error(w, "event too large", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if !authorizedEvent(req, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return nil, false
+	}
+	if !limiter.TryAccept() {
+		http.Error(w, "rate limit of the source exceeded", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return body, true
+}
+
+// writeRuns answers with the started runs, 202 when there are any.
+func writeRuns(w http.ResponseWriter, reply map[string]interface{}, started bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if started {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(reply)
+}
+
+// authorizedEvent checks the hmac signature of the body, or a bearer token, against -hmac-secret.
+func authorizedEvent(req *http.Request, body []byte) bool {
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(*hmacSecret)) == 1
+	}
+	sig := strings.TrimPrefix(req.Header.Get("X-Hub-Signature-256"), "sha256=")
+	if sig == "" {
+		sig = req.Header.Get("X-Signature-256")
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(*hmacSecret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// start runs the trigger target in the background.
+func (r *webhookReceiver) start(t *Trigger) {
+	cfg := r.copyConfig()
+	if t.Command != "" {
+		target := cfg.Target(t.Target)
+		target.Command = target.Commands[t.Command]
+	}
+	r.run(cfg, t.Target)
+}
+
+// copyConfig copies the config so the targets of one run can be changed.
+func (r *webhookReceiver) copyConfig() *Config {
+	cfg := *r.cfg
+	cfg.Targets = append([]Target(nil), r.cfg.Targets...)
+	return &cfg
+}
+
+// run runs the target of cfg and the targets following it in the background, after the
+// runs of the target started before. It is bounded by the policy exec timeout, or the timeout
+// learned for a single target.
+func (r *webhookReceiver) run(cfg *Config, name string) {
+	r.runs.Add(1)
+	go func() {
+		defer r.runs.Done()
+		mu := r.targetMutex(name)
+		mu.Lock()
+		defer mu.Unlock()
+		ctx, cancel := r.ctx, context.CancelFunc(func() {})
+		timeout, learned := RunTimeout(chainLearnedTimeout(r.clientset, cfg, name))
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		defer cancel()
+		resp := RunChain(ctx, r.clientset, r.config, cfg, name)
+		resp.LearnedTimeout = learned
+		r.outputMu.Lock()
+		SendResponse(resp)
+		r.outputMu.Unlock()
+		if *endWebhook != "" {
+			if err := PostWebhook(*endWebhook, EndWebhookReply(resp)); err != nil {
+				fmt.Fprintf(os.Stderr, "end webhook error: %v\n", err)
+			}
+		}
+	}()
+}
+
+// targetMutex returns the lock serializing the runs of the named target.
+func (r *webhookReceiver) targetMutex(name string) *sync.Mutex {
+	r.targetsMu.Lock()
+	defer r.targetsMu.Unlock()
+	mu, ok := r.targets[name]
+	if !ok {
+		mu = &sync.Mutex{}
+		r.targets[name] = mu
+	}
+	return mu
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	logsSince = flag.Duration("since", 0, "with -action logs, only lines newer than this, e.g. 1h, 0 for all")
+	logsTail  = flag.Int64("tail", 0, "with -action logs, only the last lines, 0 for all")
+)
+
+func validateLogs(cfgErr *ConfigError) {
+	if *logsSince < 0 {
+		cfgErr.Add("since", "must not be negative")
+	}
+	if *logsTail < 0 {
+		cfgErr.Add("tail", "must not be negative")
+	}
+}
+
+// LogOptions returns the log options of -since and -tail for the container.
+func LogOptions(containerName string) *corev1.PodLogOptions {
+	opts := &corev1.PodLogOptions{
+		Container: containerName,
+	}
+	if *logsSince > 0 {
+		seconds := int64((*logsSince + time.Second - 1) / time.Second)
+		opts.SinceSeconds = &seconds
+	}
+	if *logsTail > 0 {
+		opts.TailLines = logsTail
+	}
+	return opts
+}
+
+// StreamPodLogs copies the log of the container to w, at most the policy output limit of it.
+func StreamPodLogs(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string, opts *corev1.PodLogOptions, w io.Writer) error {
+	stream, err := clientset.CoreV1().Pods(namespace).GetLogs(podName, opts).Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("get logs error: %v", err)
+	}
+	defer stream.Close()
+	if _, err := io.Copy(policy.LimitOutput(w), stream); err != nil {
+		return fmt.Errorf("read logs error: %v", err)
+	}
+	return nil
+}
+
+// PodLogs returns the log of the container like StreamPodLogs.
+func PodLogs(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string, opts *corev1.PodLogOptions) (string, error) {
+	var out strings.Builder
+	err := StreamPodLogs(ctx, clientset, namespace, podName, opts, &out)
+	return NormalizeOutput(out.String()), err
+}
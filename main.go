@@ -4,10 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
@@ -23,136 +28,685 @@ var (
 	podName               = flag.String("pn", "", "pod name")
 	containerName         = flag.String("cn", "", "container name")
 	labels                = flag.String("l", "", "app=mysql,version=v1.1.2")
+	podIP                 = flag.String("pod-ip", "", "select the pod by its ip")
+	nodeName              = flag.String("node-name", "", "select pods running on this node")
 	waitRunningPodTimeout = flag.Duration("wp", time.Minute, "1m")
+	waitFor               = flag.String("wait-for", "status.phase==Running", "expression a pod must satisfy to be picked, e.g. 'status.phase==Running && conditions.Ready==True'")
+	where                 = flag.String("where", "", "expression over pod fields narrowing the candidates, like -wait-for, e.g. 'restartCount < 3 && startTime < now-1h && qosClass == Guaranteed'")
+	rankBy                = flag.String("rank-by", "", "field path ranking eligible pods, the highest value is picked, prefix - for the lowest, e.g. status.startTime; a single field, not a CEL score expression")
+	pollInterval          = flag.Duration("poll-interval", time.Second*5, "wait between running pod lookups")
+	pollMaxInterval       = flag.Duration("poll-max-interval", 0, "the lookup wait doubles up to this, 0 keeps -poll-interval fixed")
+	historyConfigMap      = flag.String("history-cm", "", "configmap to record run history in, empty to disable")
+	historyLimit          = flag.Int("history-limit", 20, "max records kept in history configmap, 0 for unlimited")
+	slowThreshold         = flag.Float64("slow-threshold", 0, "flag a run as slow when it takes this many percent longer than the median of its history, 0 disables")
+	historyTTL            = flag.Duration("history-ttl", 0, "max age of records kept in history configmap, 0 for unlimited")
+	timeoutFactor         = flag.Float64("timeout-factor", 0, "without -exec-timeout, cancel the command after this many times the p95 duration of its successful runs in the history configmap, 0 disables")
+	timeoutFloor          = flag.Duration("timeout-floor", time.Minute, "a timeout learned with -timeout-factor is never shorter than this")
+	allPods               = flag.Bool("all", false, "run in every running pod matched by -l")
+	parallel              = flag.Int("parallel", 1, "max pods executing at the same time with -all")
 	//beginWebhook          = flag.String("bw", "", "job begin webhook")
-	//endWebhook            = flag.String("ew", "", "job end webhook")
-	help = flag.Bool("h", false, "help")
+	endWebhook       = flag.String("ew", "", "job end webhook")
+	endWebhookPerPod = flag.Bool("ew-per-pod", false, "with -all, also call the end webhook for every pod")
+	tokenFile        = flag.String("token-file", "", "bearer token file used instead of the service account token, re-read when it rotates")
+	help             = flag.Bool("h", false, "help")
+
+	waitForExpr *PodExpr
+	whereExpr   *PodExpr
+	rankByRank  *PodRank
+
+	excludeLabels stringsFlag
 )
 
+func init() {
+	flag.Var(&excludeLabels, "exclude-label", "key=value excludes pods with that label, key alone excludes pods having the key, repeatable")
+}
+
+// stringsFlag collects every occurrence of a repeatable flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// podListPageSize is the page size of pod List calls.
+const podListPageSize = 100
+
 type Response struct {
-	Stdout string `json:"stdout"`
-	Stderr string `json:"stderr"`
-	Error  error  `json:"error"`
+	Target   string `json:"target,omitempty"`
+	Pod      string `json:"pod,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	// Slow is set when the run took -slow-threshold percent longer than MedianDuration.
+	Slow           bool   `json:"slow,omitempty"`
+	MedianDuration string `json:"medianDuration,omitempty"`
+	// Cancelled is set when the command was stopped by a signal or timeout, the output is partial.
+	Cancelled bool `json:"cancelled,omitempty"`
+	// LearnedTimeout is the timeout learned from the history that bounded the run.
+	LearnedTimeout time.Duration `json:"-"`
+	// PartialOutput is set when the command timed out after it wrote output.
+	PartialOutput bool `json:"-"`
+	// Started is when the command was started.
+	Started time.Time `json:"-"`
+	// Output is the head and tail of the combined output with -ew-output-kb.
+	Output *CombinedOutput `json:"-"`
+	// SkipReason is set when the command did not run because the target was unhealthy.
+	SkipReason string `json:"skipReason,omitempty"`
+	// Cooldown is set when the run was skipped because of -cooldown.
+	Cooldown bool `json:"-"`
+	// Paused is set when the run was skipped because of a -pause-from annotation.
+	Paused bool `json:"-"`
+	// ChurnedPods were picked but terminated before the exec attached, another pod was picked.
+	ChurnedPods []string `json:"churnedPods,omitempty"`
+	// TargetChurned is set when -chaos-safe found the pod gone or recreated after the command.
+	TargetChurned bool `json:"targetChurned,omitempty"`
+	// churned is set when the pod terminated before the exec attached.
+	churned    bool
+	Stdout     string        `json:"stdout"`
+	Stderr     string        `json:"stderr"`
+	StdoutFile string        `json:"stdoutFile,omitempty"`
+	StderrFile string        `json:"stderrFile,omitempty"`
+	Error      error         `json:"error"`
+	Verify     *VerifyResult `json:"verify,omitempty"`
+	Usage      *UsageStats   `json:"usage,omitempty"`
+	Report     *BatchReport  `json:"report,omitempty"`
+	Check      *CheckResult  `json:"check,omitempty"`
+	Jobs       *JobsReport   `json:"jobs,omitempty"`
+	GC         *GCReport     `json:"gc,omitempty"`
+	// PostConditions are the results of the post-conditions of the target.
+	PostConditions []*PostConditionResult `json:"postConditions,omitempty"`
+	// Steps are the responses of every target run by a config file chain.
+	Steps []*Response `json:"steps,omitempty"`
+	// Diagnostics is set when no pod could be picked.
+	Diagnostics *LookupDiagnostics `json:"diagnostics,omitempty"`
+	// FailureDiagnostics is the output of -diagnose-on-failure, sent as diagnostics.commands.
+	FailureDiagnostics []*DiagnosticOutput `json:"-"`
 }
 
 func SendError(resp *Response) {
 	SendResponse(resp)
+	WriteCIResults(resp)
+	FinishProgress(resp)
+	PostUsage(resp)
+	GrafanaEnd(resp)
+	QuitMesh()
 	os.Exit(-1)
 }
 
 func SendSuccess(resp *Response) {
 	SendResponse(resp)
+	WriteCIResults(resp)
+	FinishProgress(resp)
+	PostUsage(resp)
+	GrafanaEnd(resp)
+	QuitMesh()
 	os.Exit(0)
 }
 
 func SendResponse(resp *Response) {
+	if *eventsNDJSON {
+		EmitEvent(EventFinished, map[string]interface{}{
+			"result": BuildReply(resp),
+		})
+		return
+	}
+	if TextOutput() {
+		PrintText(os.Stdout, resp)
+		return
+	}
+	b, _ := json.Marshal(BuildReply(resp))
+	WriteLine(os.Stdout, b)
+}
+
+// Status is succeeded, failed, cancelled, timed-out-with-partial-output, skipped-unhealthy,
+// skipped-cooldown, paused or target-churned.
+func (r *Response) Status() string {
+	switch {
+	case r.Paused:
+		return PodStatusPaused
+	case r.Cooldown:
+		return PodStatusSkippedCooldown
+	case r.SkipReason != "":
+		return PodStatusSkippedUnhealthy
+	case r.TargetChurned:
+		return PodStatusTargetChurned
+	case r.Cancelled && r.PartialOutput:
+		return PodStatusTimedOutPartial
+	case r.Cancelled:
+		return PodStatusCancelled
+	case r.Error != nil:
+		return PodStatusFailed
+	}
+	return PodStatusSucceeded
+}
+
+func BuildReply(resp *Response) map[string]interface{} {
 	reply := map[string]interface{}{
 		"stdout": resp.Stdout,
 		"stderr": resp.Stderr,
 	}
 	if resp.Error != nil {
-		reply["error"] = map[string]string{
+		errObj := map[string]interface{}{
 			"message": resp.Error.Error(),
 		}
+		var cfgErr *ConfigError
+		if errors.As(resp.Error, &cfgErr) {
+			errObj["type"] = ErrorTypeInvalidConfig
+			errObj["problems"] = cfgErr.Problems
+		}
+		reply["error"] = errObj
+		if hint := ErrorHint(resp); hint != "" {
+			reply["errorHint"] = hint
+		}
+	}
+	if resp.Cancelled {
+		reply["cancelled"] = true
+		reply["status"] = resp.Status()
+	}
+	if resp.SkipReason != "" {
+		reply["status"] = resp.Status()
+		reply["skipReason"] = resp.SkipReason
+	}
+	if resp.TargetChurned {
+		reply["status"] = resp.Status()
+	}
+	if resp.Target != "" {
+		reply["target"] = resp.Target
+	}
+	if runner != nil {
+		reply["runner"] = runner
+	}
+	if *action != ActionExec {
+		reply["action"] = *action
+	}
+	if len(resp.ChurnedPods) > 0 {
+		reply["churnedPods"] = resp.ChurnedPods
+	}
+	if resp.Pod != "" {
+		reply["pod"] = resp.Pod
+	}
+	if !resp.Started.IsZero() {
+		reply["started"] = resp.Started.UTC().Format(time.RFC3339)
+	}
+	if resp.Duration != "" {
+		reply["duration"] = resp.Duration
+	}
+	if resp.LearnedTimeout > 0 {
+		reply["learnedTimeout"] = resp.LearnedTimeout.String()
+	}
+	if resp.MedianDuration != "" {
+		reply["slow"] = resp.Slow
+		reply["medianDuration"] = resp.MedianDuration
+	}
+	if resp.StdoutFile != "" {
+		reply["stdoutFile"] = resp.StdoutFile
+	}
+	if resp.StderrFile != "" {
+		reply["stderrFile"] = resp.StderrFile
+	}
+	if resp.Verify != nil {
+		reply["verify"] = resp.Verify
+	}
+	if len(resp.PostConditions) > 0 {
+		reply["postConditions"] = resp.PostConditions
+	}
+	if resp.Usage != nil {
+		reply["usage"] = resp.Usage
+	}
+	if resp.Report != nil {
+		reply["report"] = resp.Report
+	}
+	if resp.Check != nil {
+		reply["check"] = resp.Check
+	}
+	if resp.Jobs != nil {
+		reply["jobs"] = resp.Jobs
+	}
+	if resp.GC != nil {
+		reply["gc"] = resp.GC
+	}
+	if resp.Diagnostics != nil {
+		reply["diagnostics"] = resp.Diagnostics
+	} else if len(resp.FailureDiagnostics) > 0 {
+		// no pod was picked for lookup diagnostics, both never come together.
+		reply["diagnostics"] = map[string]interface{}{
+			"commands": resp.FailureDiagnostics,
+		}
 	}
-	b, _ := json.Marshal(reply)
-	fmt.Println(string(b))
+	if len(resp.Steps) > 0 {
+		steps := make([]map[string]interface{}, 0, len(resp.Steps))
+		for _, step := range resp.Steps {
+			steps = append(steps, BuildReply(step))
+		}
+		reply["steps"] = steps
+	}
+	if retries := APIRetries(); retries > 0 {
+		reply["apiRetries"] = retries
+	}
+	reply["build"] = GetBuildInfo()
+	if runLabels := RunLabels(); runLabels != nil {
+		reply["labels"] = runLabels
+	}
+	return reply
 }
 
 func main() {
-	flag.Parse()
+	args := os.Args[1:]
+	subcommand := ""
+	if len(args) > 0 && (args[0] == "targets" || args[0] == "rbac" || args[0] == "check" || args[0] == "version" || args[0] == "reassemble" || args[0] == "gc" || args[0] == "validate") {
+		subcommand, args = args[0], args[1:]
+	}
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.CommandLine.SetOutput(ioutil.Discard)
+	if err := flag.CommandLine.Parse(args); err == flag.ErrHelp {
+		*help = true
+	} else if err != nil {
+		cfgErr := &ConfigError{}
+		cfgErr.Add("", "%v", err)
+		SendError(&Response{
+			Error: cfgErr,
+		})
+	}
+	if err := ApplyEnv(flag.CommandLine); err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	if err := LoadPolicy(); err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	ApplyPluginDefaults()
 	if *help {
-		fmt.Println("k8s-cronjob [options] command in container")
+		fmt.Println(programName() + " [options] command in container")
+		fmt.Println(programName() + " -f config.yaml [-run target] [options]")
+		fmt.Println(programName() + " -jobs jobs.yaml [options]")
+		fmt.Println(programName() + " -profile name [options]")
+		fmt.Println(programName() + " -post-deploy deployment/name [options] verification command")
+		fmt.Println(programName() + " targets [options]")
+		fmt.Println(programName() + " check [-expect-pods n] [-expect-leader expr] [-expect-image pattern] [options]")
+		fmt.Println(programName() + " reassemble < pod.log")
+		fmt.Println(programName() + " version [-check-update -release-manifest url]")
+		fmt.Println(programName() + " rbac [-f config.yaml] [-sa name] [-sa-ns namespace] [options]")
+		fmt.Println(programName() + " gc [-ns namespace | -f config.yaml] [-gc-ttl 168h] [-gc-dry-run]")
+		fmt.Println(programName() + " validate -f config.yaml [-validate-offline] [options]")
+		return
+	}
+	switch subcommand {
+	case "targets":
+		RunTargets()
+		return
+	case "rbac":
+		RunRBAC()
+		return
+	case "check":
+		RunCheck()
+		return
+	case "version":
+		RunVersion()
+		return
+	case "reassemble":
+		RunReassemble()
+		return
+	case "gc":
+		RunGC()
+		return
+	case "validate":
+		RunValidate()
 		return
 	}
-	if *labels == "" && *podName == "" {
+	cmd := flag.Args()
+	// the flag package drops the -- ending the options, remember whether it was there.
+	commandSeparated = len(cmd) < len(args) && args[len(args)-len(cmd)-1] == "--"
+	cfgErr := &ConfigError{}
+	validateRunMode(cfgErr)
+	if err := cfgErr.ErrOrNil(); err != nil {
 		SendError(&Response{
-			Error: fmt.Errorf("labels and pod name all empty"),
+			Error: err,
 		})
 	}
-	cmd := flag.Args()
-	config, err := rest.InClusterConfig()
-	if err != nil {
+	if *listenWebhook != "" {
+		RunWebhookReceiver(cmd)
+		return
+	}
+	if *profileName != "" {
+		RunProfile(cmd)
+		return
+	}
+	if *configFile != "" {
+		RunConfig(cmd)
+		return
+	}
+	if *jobsFile != "" {
+		RunJobs(cmd)
+		return
+	}
+	if err := ValidateFlags(cmd); err != nil {
 		SendError(&Response{
-			Error: fmt.Errorf("load cluster config error: %v", err),
+			Error: err,
 		})
 	}
-	clientset, err := kubernetes.NewForConfig(config)
+	cmd = WrapCommand(cmd)
+	selector, _ := BuildLabelSelector()
+	fieldSelector := BuildFieldSelector(*podIP, *nodeName)
+	CompilePodSelection()
+	config, clientset, err := NewClient()
 	if err != nil {
 		SendError(&Response{
-			Error: fmt.Errorf("create cluster client error: %v", err),
+			Error: err,
 		})
 	}
-	var (
-		runningPodName string
-	)
-	if *waitRunningPodTimeout > 0 {
-		runningPodName, err = LookupRunningPodTimeout(clientset, *namespace, *labels, *podName, *containerName, *waitRunningPodTimeout)
+	ctx, cancel := ExecContext(clientset, LearnedTimeout(clientset, *namespace, *historyConfigMap, cmd))
+	defer cancel()
+	GrafanaStart(Redact(strings.Join(cmd, " ")))
+	cooldownTarget := FlagsTarget(cmd)
+	if resp := SkipRun(ctx, clientset, &cooldownTarget); resp != nil {
+		finish(clientset, resp)
+	}
+	// a failed run gives the cooldown back, so a retry of the Job runs.
+	finishRun := func(resp *Response) {
+		if resp.Error != nil {
+			ReleaseCooldown(clientset, &cooldownTarget)
+		}
+		finish(clientset, resp)
+	}
+	if *postDeploy != "" {
+		resp := RunPostDeploy(ctx, clientset, config, cmd)
+		WriteTerminationMessage(resp)
+		finishRun(resp)
+	}
+	if isWorkloadAction() {
+		resp := RunWorkloadAction(ctx, clientset)
+		finishRun(resp)
+	}
+	if *allPods {
+		target := FlagsTarget(cmd)
+		var report *BatchReport
+		if *statefulSet != "" {
+			report = RunStatefulSet(ctx, clientset, config, &target)
+		} else {
+			report = RunFanOut(ctx, clientset, config, &target)
+		}
+		resp := &Response{
+			Report: report,
+		}
+		if err := report.Err(); err != nil {
+			resp.Error = err
+		} else if report.Succeeded+report.Failed == 0 {
+			resp.Error = fmt.Errorf("no running pod found")
+			resp.Diagnostics = DiagnoseLookup(clientset, *namespace, selector, fieldSelector, "")
+		}
+		finishRun(resp)
+	}
+	var resp *Response
+	if *jobName != "" {
+		resp = RunJobTarget(ctx, clientset, config, cmd)
 	} else {
-		runningPodName, err = LookupRunningPod(clientset, *namespace, *labels, *podName, *containerName)
+		target := FlagsTarget(cmd)
+		resp = SelectAndRun(ctx, clientset, config, &target, nil)
+	}
+	finishRun(resp)
+}
+
+// finish reports the end of the run to -ew and -annotate-job, then exits like SendError or
+// SendSuccess.
+func finish(clientset *kubernetes.Clientset, resp *Response) {
+	resp.LearnedTimeout = learnedTimeout
+	if *endWebhook != "" {
+		if err := PostWebhook(*endWebhook, EndWebhookReply(resp)); err != nil {
+			fmt.Fprintf(os.Stderr, "end webhook error: %v\n", err)
+		}
+	}
+	if *annotateJob {
+		if err := AnnotateRunnerJob(clientset, resp); err != nil {
+			fmt.Fprintf(os.Stderr, "annotate job error: %v\n", err)
+		}
+	}
+	if resp.Error != nil {
+		SendError(resp)
 	}
+	SendSuccess(resp)
+}
+
+func NewClient() (*rest.Config, *kubernetes.Clientset, error) {
+	config, err := LoadRESTConfig()
 	if err != nil {
-		SendError(&Response{
-			Error: fmt.Errorf("lookup running pod error: %v", err),
-		})
+		return nil, nil, err
+	}
+	if *tokenFile != "" {
+		// client-go re-reads a token file periodically, so rotated tokens are picked up.
+		config.BearerToken = ""
+		config.BearerTokenFile = *tokenFile
+	}
+	if err := ApplyClientOptions(config); err != nil {
+		return nil, nil, err
 	}
-	stdoutStr, stderrStr, err := ExecInPod(clientset, config, *namespace, runningPodName, *containerName, cmd)
+	ApplyUsageAccounting(config)
+	if err := ApplyProxy(config); err != nil {
+		return nil, nil, err
+	}
+	if err := ApplySSHTunnel(config); err != nil {
+		return nil, nil, err
+	}
+	if err := WaitMeshReady(); err != nil {
+		return nil, nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		SendError(&Response{
-			Stdout: stdoutStr,
-			Stderr: stderrStr,
-			Error:  err,
-		})
+		return nil, nil, fmt.Errorf("create cluster client error: %v", err)
 	}
-	SendSuccess(&Response{
-		Stdout: stdoutStr,
-		Stderr: stderrStr,
-		Error:  nil,
-	})
+	ResolveRunner(clientset)
+	DetectServerFeatures(clientset)
+	return config, clientset, nil
 }
 
-func LookupRunningPodTimeout(clientset *kubernetes.Clientset, namespace string, labels string, podName string, containerName string, timeout time.Duration) (string, error) {
-	start := time.Now()
+// LookupRunningPodTimeout polls until a running pod is found, starting at -poll-interval and
+// doubling the wait up to -poll-max-interval. An in-flight lookup is cancelled at timeout.
+func LookupRunningPodTimeout(ctx context.Context, clientset *kubernetes.Clientset, namespace string, labels string, fields string, podName string, containerName string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	interval := *pollInterval
 	for {
-		podName, err := LookupRunningPod(clientset, namespace, labels, podName, containerName)
+		podName, err := LookupRunningPod(ctx, clientset, namespace, labels, fields, podName, containerName)
 		if err == nil {
 			return podName, nil
 		}
-		if time.Since(start) > timeout {
-			return "", fmt.Errorf("lookup running pod timeout")
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("lookup running pod timeout: %v", err)
+		case <-time.After(interval):
+		}
+		if interval*2 <= *pollMaxInterval {
+			interval *= 2
+		} else if *pollMaxInterval > interval {
+			interval = *pollMaxInterval
 		}
-		time.Sleep(time.Second * 5)
 	}
 }
 
-func LookupRunningPod(clientset *kubernetes.Clientset, namespace string, labels string, podName string, containerName string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
+func LookupRunningPod(ctx context.Context, clientset *kubernetes.Clientset, namespace string, labels string, fields string, podName string, containerName string) (string, error) {
+	var pod *corev1.Pod
 	if podName != "" {
-		pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, v1.GetOptions{})
+		pods, err := ListCandidatePods(ctx, clientset, namespace, labels, fields, podName)
+		if err != nil {
+			return "", err
+		}
+		pod = PickPod(pods)
+	} else if *preferIdle || *preferZone != "" || *sameZoneAs != "" || *service != "" {
+		zone, err := PreferredZone(ctx, clientset, namespace)
+		if err != nil {
+			return "", fmt.Errorf("resolve preferred zone error: %v", err)
+		}
+		pods, err := ListCandidatePods(ctx, clientset, namespace, labels, fields, "")
 		if err != nil {
 			return "", err
 		}
-		if pod.Status.Phase == corev1.PodRunning {
-			return pod.Name, nil
+		if zone != "" {
+			pods = FilterZone(ctx, clientset, pods, zone)
 		}
+		if *preferIdle {
+			pod = PickIdlePod(ctx, clientset, namespace, labels, pods)
+		} else {
+			pod = PickPod(pods)
+		}
+	} else {
+		var err error
+		if pod, err = findPod(ctx, clientset, namespace, labels, fields); err != nil {
+			return "", err
+		}
+	}
+	if pod == nil {
 		return "", fmt.Errorf("no running pod found")
 	}
-	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, v1.ListOptions{
+	return pod.Name, nil
+}
+
+// findPod pages through the pods matching labels and returns the one PickPod picks. Without
+// -rank-by it stops at the first eligible pod. Only running pods are listed when -wait-for
+// requires it.
+func findPod(ctx context.Context, clientset *kubernetes.Clientset, namespace string, labels string, fields string) (*corev1.Pod, error) {
+	opts := v1.ListOptions{
+		LabelSelector: labels,
+		FieldSelector: fields,
+	}
+	if waitForExpr == nil || waitForExpr.RequiresRunning() {
+		opts.FieldSelector = joinFieldSelectors(fields, "status.phase="+string(corev1.PodRunning))
+	}
+	var picked *corev1.Pod
+	err := listPodPages(ctx, clientset, namespace, opts, func(pods []corev1.Pod) bool {
+		pod := PickPod(pods)
+		if pod == nil {
+			return true
+		}
+		if picked == nil || (rankByRank != nil && rankByRank.Better(pod, picked)) {
+			picked = pod
+		}
+		return rankByRank != nil
+	})
+	return picked, err
+}
+
+// listPodPages lists pods podListPageSize at a time, calling fn with every page until it returns false.
+func listPodPages(ctx context.Context, clientset *kubernetes.Clientset, namespace string, opts v1.ListOptions, fn func([]corev1.Pod) bool) error {
+	opts.Limit = podListPageSize
+	for {
+		var pods *corev1.PodList
+		err := RetryAPI(ctx, func() (err error) {
+			ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+			defer cancel()
+			pods, err = clientset.CoreV1().Pods(namespace).List(ctx, opts)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !fn(pods.Items) || pods.Continue == "" {
+			return nil
+		}
+		opts.Continue = pods.Continue
+	}
+}
+
+// ListCandidatePods returns the pod named podName, or all pods matching labels and fields when
+// podName is empty, narrowed to the -service endpoints.
+func ListCandidatePods(ctx context.Context, clientset *kubernetes.Clientset, namespace string, labels string, fields string, podName string) ([]corev1.Pod, error) {
+	if podName != "" {
+		var pod *corev1.Pod
+		err := RetryAPI(ctx, func() (err error) {
+			ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+			defer cancel()
+			pod, err = clientset.CoreV1().Pods(namespace).Get(ctx, podName, v1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []corev1.Pod{*pod}, nil
+	}
+	var all []corev1.Pod
+	err := listPodPages(ctx, clientset, namespace, v1.ListOptions{
 		LabelSelector: labels,
+		FieldSelector: fields,
+	}, func(pods []corev1.Pod) bool {
+		all = append(all, pods...)
+		return true
 	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	for _, pod := range pods.Items {
-		if pod.Status.Phase == corev1.PodRunning {
-			return pod.Name, nil
+	if *service != "" {
+		return FilterService(ctx, clientset, namespace, all)
+	}
+	return all, nil
+}
+
+// PickPod returns the pod a run would exec into, nil if none is eligible.
+// With -rank-by the highest ranked eligible pod is returned, otherwise the first one.
+func PickPod(pods []corev1.Pod) *corev1.Pod {
+	var picked *corev1.Pod
+	for i := range pods {
+		if !IsEligiblePod(&pods[i]) {
+			continue
 		}
+		if rankByRank == nil {
+			return &pods[i]
+		}
+		if picked == nil || rankByRank.Better(&pods[i], picked) {
+			picked = &pods[i]
+		}
+	}
+	return picked
+}
+
+// CompilePodSelection parses the validated -wait-for, -where and -rank-by flags.
+func CompilePodSelection() {
+	waitForExpr, _ = ParsePodExpr(*waitFor)
+	if *where != "" {
+		whereExpr, _ = ParsePodExpr(*where)
+	}
+	if *rankBy != "" {
+		rankByRank, _ = ParsePodRank(*rankBy)
 	}
-	return "", fmt.Errorf("no running pod found")
 }
 
-func ExecInPod(clientset *kubernetes.Clientset, config *rest.Config, namespace string, podName string, containerName string, cmd []string) (string, string, error) {
+// IsEligiblePod reports whether the pod satisfies -wait-for, by default being Running, and -where.
+func IsEligiblePod(pod *corev1.Pod) bool {
+	if whereExpr != nil {
+		if ok, err := whereExpr.Match(pod); err != nil || !ok {
+			return false
+		}
+	}
+	if waitForExpr == nil {
+		return pod.Status.Phase == corev1.PodRunning
+	}
+	ok, err := waitForExpr.Match(pod)
+	return err == nil && ok
+}
+
+// ExecInPod runs cmd in the pod and returns its trimmed output. When ctx is done before the
+// command finishes, the output received so far is returned with an exec cancelled error.
+func ExecInPod(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, namespace string, podName string, containerName string, cmd []string) (string, string, error) {
+	var stdout, stderr syncBuffer
+	err := ExecInPodTo(ctx, clientset, config, namespace, podName, containerName, cmd, nil, &stdout, &stderr)
+	stdoutStr := NormalizeOutput(strings.TrimSpace(stdout.String()))
+	stderrStr := NormalizeOutput(strings.TrimSpace(stderr.String()))
+	if err != nil {
+		return stdoutStr, stderrStr, err
+	}
+	if stderrStr != "" {
+		return stdoutStr, stderrStr, fmt.Errorf(stderrStr)
+	}
+	return stdoutStr, stderrStr, nil
+
+}
+
+// ExecInPodTo runs cmd in the pod streaming stdin, when not nil, to it and its output to stdout
+// and stderr. The writers may still be written to after returning because ctx was done.
+func ExecInPodTo(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, namespace string, podName string, containerName string, cmd []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
 	req := clientset.CoreV1().RESTClient().Post().
 		Resource("pods").
 		Name(podName).
@@ -163,7 +717,7 @@ func ExecInPod(clientset *kubernetes.Clientset, config *rest.Config, namespace s
 	req.VersionedParams(
 		&corev1.PodExecOptions{
 			Command: cmd,
-			Stdin:   false,
+			Stdin:   stdin != nil,
 			Stdout:  true,
 			Stderr:  true,
 			TTY:     false,
@@ -171,24 +725,112 @@ func ExecInPod(clientset *kubernetes.Clientset, config *rest.Config, namespace s
 		scheme.ParameterCodec,
 	)
 
-	var stdout, stderr bytes.Buffer
-	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
-	if err != nil {
-		return "", "", err
+	if stdin != nil {
+		if err := policy.CheckStdin(); err != nil {
+			return err
+		}
 	}
-	err = exec.Stream(remotecommand.StreamOptions{
-		Stdin:  nil,
-		Stdout: &stdout,
-		Stderr: &stderr,
-	})
-	stdoutStr := strings.TrimSpace(stdout.String())
-	stderrStr := strings.TrimSpace(stderr.String())
+	exec, err := newSPDYExecutor(config, "POST", req.URL())
 	if err != nil {
-		return stdoutStr, stderrStr, err
+		return err
 	}
-	if stderrStr != "" {
-		return stdoutStr, stderrStr, fmt.Errorf(stderrStr)
+	done := make(chan error, 1)
+	go func() {
+		done <- streamWithRetry(ctx, exec, stdin, &countingWriter{w: policy.LimitOutput(stdout)}, &countingWriter{w: policy.LimitOutput(stderr)})
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// take what is still on its way, the stream does not end with the context.
+		select {
+		case <-done:
+		case <-time.After(cancelDrainTimeout):
+		}
+		err = fmt.Errorf("exec cancelled: %v", ctx.Err())
 	}
-	return stdoutStr, stderrStr, nil
+	return err
+}
+
+// streamWithRetry retries api errors before the command started. An -idempotent command is
+// started over when its stream was dropped.
+func streamWithRetry(ctx context.Context, exec remotecommand.Executor, stdin io.Reader, stdout *countingWriter, stderr *countingWriter) error {
+	for attempt := 0; ; attempt++ {
+		err := streamOnce(ctx, exec, stdin, stdout, stderr)
+		if err == nil || !*idempotent || stdin != nil || attempt >= *execReconnects || ctx.Err() != nil || !isStreamDropped(err) {
+			return err
+		}
+		if !restartStream(err, stdout, stderr) {
+			return err
+		}
+	}
+}
+
+func streamOnce(ctx context.Context, exec remotecommand.Executor, stdin io.Reader, stdout *countingWriter, stderr *countingWriter) error {
+	err := RetryAPI(ctx, func() error {
+		err := exec.Stream(remotecommand.StreamOptions{
+			Stdin:  stdin,
+			Stdout: stdout,
+			Stderr: stderr,
+		})
+		if err != nil && (stdin != nil || stdout.Count() > 0 || stderr.Count() > 0) {
+			// the command may have started or consumed stdin, running it again is not safe.
+			return &nonRetryableError{err}
+		}
+		return err
+	})
+	var nre *nonRetryableError
+	if errors.As(err, &nre) {
+		err = nre.err
+	}
+	return err
+}
+
+// syncBuffer is a bytes.Buffer safe to read while the exec stream is still writing to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Reset() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+	return nil
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func (c *countingWriter) Count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// nonRetryableError stops RetryAPI from retrying an otherwise retryable error.
+type nonRetryableError struct {
+	err error
+}
 
+func (e *nonRetryableError) Error() string {
+	return e.err.Error()
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	MeshIstio   = "istio"
+	MeshLinkerd = "linkerd"
+
+	defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+)
+
+var (
+	mesh             = flag.String("mesh", "", "istio or linkerd, wait for the sidecar of the runner pod and skip the mesh proxy when choosing the target container")
+	meshReadyTimeout = flag.Duration("mesh-ready-timeout", time.Minute, "how long to wait for the sidecar of the runner pod")
+	meshQuit         = flag.Bool("mesh-quit", false, "stop the sidecar of the runner pod on exit, so the job pod can complete")
+)
+
+type meshEndpoints struct {
+	proxyContainer string
+	ready          string
+	quit           string
+}
+
+var meshes = map[string]meshEndpoints{
+	MeshIstio: {
+		proxyContainer: "istio-proxy",
+		ready:          "http://localhost:15021/healthz/ready",
+		quit:           "http://localhost:15020/quitquitquit",
+	},
+	MeshLinkerd: {
+		proxyContainer: "linkerd-proxy",
+		ready:          "http://localhost:4191/ready",
+		quit:           "http://localhost:4191/shutdown",
+	},
+}
+
+// WaitMeshReady polls the sidecar of the runner pod until it is ready, the api server
+// is not reachable before.
+func WaitMeshReady() error {
+	endpoints, ok := meshes[*mesh]
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *meshReadyTimeout)
+	defer cancel()
+	for {
+		err := meshRequest(ctx, http.MethodGet, endpoints.ready)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait for %s sidecar error: %v", *mesh, err)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// QuitMesh asks the sidecar of the runner pod to exit when -mesh-quit is set.
+func QuitMesh() {
+	endpoints, ok := meshes[*mesh]
+	if !ok || !*meshQuit {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := meshRequest(ctx, http.MethodPost, endpoints.quit); err != nil {
+		fmt.Fprintf(os.Stderr, "quit %s sidecar error: %v\n", *mesh, err)
+	}
+}
+
+func meshRequest(ctx context.Context, method string, url string) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return nil
+}
+
+// MeshContainer chooses the target container when none is given: the default-container
+// annotation of the pod, else its first container that is not the mesh proxy.
+func MeshContainer(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string) (string, error) {
+	endpoints, ok := meshes[*mesh]
+	if !ok {
+		return "", nil
+	}
+	var pod *corev1.Pod
+	err := RetryAPI(ctx, func() (err error) {
+		ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+		defer cancel()
+		pod, err = clientset.CoreV1().Pods(namespace).Get(ctx, podName, v1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if name := pod.Annotations[defaultContainerAnnotation]; name != "" {
+		return name, nil
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name != endpoints.proxyContainer {
+			return c.Name, nil
+		}
+	}
+	return "", nil
+}
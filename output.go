@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	stdoutFile = flag.String("stdout-file", "", "write the command stdout to this file instead of the result, {{pod}} is replaced by the pod name")
+	stderrFile = flag.String("stderr-file", "", "write the command stderr to this file instead of the result, {{pod}} is replaced by the pod name")
+)
+
+// ExecOutput is the output of a command, when an output went to a file its string is empty.
+type ExecOutput struct {
+	Stdout     string
+	Stderr     string
+	StdoutFile string
+	StderrFile string
+	// Combined is the head and tail of stdout and stderr together with -ew-output-kb.
+	Combined *CombinedOutput
+}
+
+// ExecWithOutputs runs cmd like ExecInPod, feeding it stdin when not nil and sending stdout
+// and stderr to -stdout-file and -stderr-file when they are set. Output written to a file is
+// kept byte for byte. With -record-cast the output is also recorded for replay. With -action
+// logs the container log is the stdout instead.
+func ExecWithOutputs(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, namespace string, podName string, containerName string, cmd []string, stdin io.Reader) (*ExecOutput, error) {
+	out := &ExecOutput{
+		StdoutFile: outputFileName(*stdoutFile, podName),
+		StderrFile: outputFileName(*stderrFile, podName),
+	}
+	var stdoutBuf, stderrBuf syncBuffer
+	var stdout, stderr io.Writer = &stdoutBuf, &stderrBuf
+	if out.StdoutFile != "" {
+		f, err := os.Create(out.StdoutFile)
+		if err != nil {
+			return out, fmt.Errorf("create stdout file error: %v", err)
+		}
+		defer f.Close()
+		stdout = f
+	}
+	stderrCount := &countingWriter{w: &stderrBuf}
+	stderr = stderrCount
+	if out.StderrFile != "" {
+		f, err := os.Create(out.StderrFile)
+		if err != nil {
+			return out, fmt.Errorf("create stderr file error: %v", err)
+		}
+		defer f.Close()
+		stderrCount.w = f
+	}
+	// flush ends the lines the redacting writers hold back.
+	var flush []func()
+	if *eventsNDJSON {
+		stdoutEvents := &eventWriter{w: stdout, pod: podName, stream: "stdout"}
+		stderrEvents := &eventWriter{w: stderr, pod: podName, stream: "stderr"}
+		stdout, stderr = stdoutEvents, stderrEvents
+		flush = append(flush, stdoutEvents.Flush, stderrEvents.Flush)
+	}
+	if *statusFile != "" || *usageWebhook != "" {
+		stdout = &progressWriter{w: stdout, n: &progressStdout}
+		stderr = &progressWriter{w: stderr, n: &progressStderr}
+	}
+	if *recordCast != "" {
+		recorder, err := newCastRecorder(outputFileName(*recordCast, podName), podName, cmd)
+		if err != nil {
+			return out, err
+		}
+		defer recorder.Close()
+		stdoutCast := &castWriter{w: stdout, r: recorder}
+		stderrCast := &castWriter{w: stderr, r: recorder}
+		stdout, stderr = stdoutCast, stderrCast
+		flush = append(flush, stdoutCast.Flush, stderrCast.Flush)
+	}
+	var tail *outputTail
+	if *endWebhook != "" && *endWebhookOutputKB > 0 {
+		tail = newOutputTail(*endWebhookOutputKB * 1024)
+		stdout = &tailWriter{w: stdout, t: tail}
+		stderr = &tailWriter{w: stderr, t: tail}
+	}
+	var err error
+	if *action == ActionLogs {
+		err = StreamPodLogs(ctx, clientset, namespace, podName, LogOptions(containerName), stdout)
+	} else {
+		err = ExecInPodTo(ctx, clientset, config, namespace, podName, containerName, cmd, stdin, stdout, stderr)
+	}
+	for _, f := range flush {
+		f()
+	}
+	out.Stdout = NormalizeOutput(strings.TrimSpace(stdoutBuf.String()))
+	out.Stderr = NormalizeOutput(strings.TrimSpace(stderrBuf.String()))
+	if tail != nil {
+		out.Combined = tail.Output()
+	}
+	if err != nil {
+		return out, err
+	}
+	if out.Stderr != "" {
+		return out, fmt.Errorf(out.Stderr)
+	}
+	if out.StderrFile != "" && stderrCount.Count() > 0 {
+		return out, fmt.Errorf("command wrote to stderr, see %s", out.StderrFile)
+	}
+	return out, nil
+}
+
+func outputFileName(pattern string, podName string) string {
+	return strings.ReplaceAll(pattern, "{{pod}}", podName)
+}
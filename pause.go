@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	PodStatusPaused = "paused"
+	// AnnotationPaused set to "true" on a -pause-from object skips the runs until removed.
+	AnnotationPaused = "cronexec.puper.io/paused"
+)
+
+var pauseFrom stringsFlag
+
+func init() {
+	flag.Var(&pauseFrom, "pause-from", "configmap/name or lease/name in the target namespace, while it is annotated "+AnnotationPaused+"=true runs are skipped as paused, repeatable")
+}
+
+func validatePauseFrom(cfgErr *ConfigError) {
+	for _, ref := range pauseFrom {
+		kind, name := splitWorkloadRef(ref)
+		if (kind != "configmap" && kind != "lease") || name == "" {
+			cfgErr.Add("pause-from", "malformed %q, want configmap/name or lease/name", ref)
+		}
+	}
+}
+
+// CheckPaused returns a response skipping the run when one of the -pause-from objects is
+// annotated as paused, nil otherwise. A missing object does not pause, so deleting a pause
+// lease resumes the runs like removing the annotation.
+func CheckPaused(ctx context.Context, clientset *kubernetes.Clientset, target *Target) *Response {
+	for _, ref := range pauseFrom {
+		kind, name := splitWorkloadRef(ref)
+		var meta *v1.ObjectMeta
+		err := RetryAPI(ctx, func() error {
+			switch kind {
+			case "configmap":
+				cm, err := clientset.CoreV1().ConfigMaps(target.Namespace).Get(ctx, name, v1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				meta = &cm.ObjectMeta
+			default:
+				lease, err := clientset.CoordinationV1().Leases(target.Namespace).Get(ctx, name, v1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				meta = &lease.ObjectMeta
+			}
+			return nil
+		})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return &Response{
+				Target: target.Name,
+				Error:  fmt.Errorf("get pause %s error: %v", ref, err),
+			}
+		}
+		if meta.Annotations[AnnotationPaused] == "true" {
+			return &Response{
+				Target:     target.Name,
+				SkipReason: fmt.Sprintf("paused by %s %s in %s", AnnotationPaused, ref, target.Namespace),
+				Paused:     true,
+			}
+		}
+	}
+	return nil
+}
+
+// SkipRun returns the response of a run that is paused or within its -cooldown, nil when
+// the run may start. A paused run does not claim the cooldown.
+func SkipRun(ctx context.Context, clientset *kubernetes.Clientset, target *Target) *Response {
+	if resp := CheckPaused(ctx, clientset, target); resp != nil {
+		return resp
+	}
+	return ClaimCooldown(ctx, clientset, target)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// pluginMode is set when the binary is installed as a kubectl plugin, e.g. kubectl-cron_exec
+// run as kubectl cron-exec.
+var pluginMode = strings.HasPrefix(filepath.Base(os.Args[0]), "kubectl-")
+
+func init() {
+	// the flag names kubectl users type, they set the same values as -ns, -l and -cn. Only for
+	// the plugin, -c and -n would make commands like sh -c need -- before them.
+	if !pluginMode {
+		return
+	}
+	flag.StringVar(namespace, "n", "default", "alias of -ns")
+	flag.StringVar(namespace, "namespace", "default", "alias of -ns")
+	flag.StringVar(labels, "selector", "", "alias of -l")
+	flag.StringVar(containerName, "c", "", "alias of -cn")
+	flag.StringVar(containerName, "container", "", "alias of -cn")
+}
+
+// programName is how the usage refers to the binary.
+func programName() string {
+	if pluginMode {
+		return "kubectl cron-exec"
+	}
+	return "k8s-cronjob"
+}
+
+// ApplyPluginDefaults makes the namespace default to the one of the kubeconfig context, like
+// kubectl does, when running as a plugin and no namespace was given.
+func ApplyPluginDefaults() {
+	if !pluginMode {
+		return
+	}
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "ns" || f.Name == "n" || f.Name == "namespace" {
+			set = true
+		}
+	})
+	if set {
+		return
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = *kubeconfig
+	ns, _, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{
+		CurrentContext: *kubeContext,
+	}).Namespace()
+	if err == nil && ns != "" {
+		*namespace = ns
+	}
+}
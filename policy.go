@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	deniedNamespaces  = flag.String("denied-namespaces", "", "comma separated namespaces, or patterns like kube-*, that are never targeted")
+	allowedNamespaces = flag.String("allowed-namespaces", "", "comma separated namespaces, or patterns, the only ones that may be targeted, empty allows all")
+)
+
+// policyFile is read on every run and can not be changed by options or the environment, so an
+// image can ship a policy its users can not lift. Set at build time with
+// -ldflags "-X main.policyFile=/path/policy.yaml".
+var policyFile = "/etc/k8s-cronjob/policy.yaml"
+
+// Policy is the content of the policy file, it applies on top of the options.
+type Policy struct {
+	DeniedNamespaces  []string `json:"deniedNamespaces,omitempty"`
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	// ForbidTTY is accepted so policies shared with other exec tools load, k8s-cronjob never
+	// allocates a tty.
+	ForbidTTY bool `json:"forbidTTY,omitempty"`
+	// ForbidCopyFrom rejects -action fetch, which copies files out of pods.
+	ForbidCopyFrom bool `json:"forbidCopyFrom,omitempty"`
+	// ForbidStdin rejects feeding stdin to commands, e.g. stdinFromPrevious targets.
+	ForbidStdin bool `json:"forbidStdin,omitempty"`
+	// MaxExecTimeout caps -exec-timeout and applies when it is not set, e.g. 30m.
+	MaxExecTimeout string `json:"maxExecTimeout,omitempty"`
+	// MaxOutputBytes caps stdout and stderr of every command, the rest is dropped.
+	MaxOutputBytes int64 `json:"maxOutputBytes,omitempty"`
+	// DeniedActions lists the -action values that may not be used, e.g. restart.
+	DeniedActions []string `json:"deniedActions,omitempty"`
+
+	maxExecTimeout time.Duration
+}
+
+var policy = &Policy{}
+
+// LoadPolicy reads the policy file, a missing file is an empty policy.
+func LoadPolicy() error {
+	b, err := ioutil.ReadFile(policyFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read policy error: %v", err)
+	}
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(b, p); err != nil {
+		return fmt.Errorf("parse policy %s error: %v", policyFile, err)
+	}
+	if p.MaxExecTimeout != "" {
+		if p.maxExecTimeout, err = time.ParseDuration(p.MaxExecTimeout); err != nil || p.maxExecTimeout <= 0 {
+			return fmt.Errorf("policy %s: maxExecTimeout must be a positive duration", policyFile)
+		}
+	}
+	if p.MaxOutputBytes < 0 {
+		return fmt.Errorf("policy %s: maxOutputBytes must not be negative", policyFile)
+	}
+	policy = p
+	return nil
+}
+
+// ExecTimeout is -exec-timeout capped by the policy, 0 for no timeout.
+func (p *Policy) ExecTimeout() time.Duration {
+	if p.maxExecTimeout > 0 && (*execTimeout <= 0 || *execTimeout > p.maxExecTimeout) {
+		return p.maxExecTimeout
+	}
+	return *execTimeout
+}
+
+// CheckStdin returns an error when the policy forbids feeding stdin.
+func (p *Policy) CheckStdin() error {
+	if p.ForbidStdin {
+		return fmt.Errorf("stdin is forbidden by policy %s", policyFile)
+	}
+	return nil
+}
+
+// CheckAction returns an error when the policy denies the action.
+func (p *Policy) CheckAction(action string) error {
+	for _, denied := range p.DeniedActions {
+		if denied == action {
+			return fmt.Errorf("%s is denied by policy %s", action, policyFile)
+		}
+	}
+	return nil
+}
+
+// LimitOutput wraps w so it receives at most MaxOutputBytes.
+func (p *Policy) LimitOutput(w io.Writer) io.Writer {
+	if p.MaxOutputBytes <= 0 {
+		return w
+	}
+	return &limitWriter{w: w, limit: p.MaxOutputBytes}
+}
+
+// limitWriter drops what is written past limit and notes the truncation once, it keeps
+// accepting writes so the command is not broken by the cap.
+type limitWriter struct {
+	w     io.Writer
+	limit int64
+	n     int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	written := atomic.AddInt64(&l.n, int64(len(p))) - int64(len(p))
+	if written >= l.limit {
+		return len(p), nil
+	}
+	if rest := l.limit - written; int64(len(p)) > rest {
+		if _, err := l.w.Write(p[:rest]); err != nil {
+			return 0, err
+		}
+		fmt.Fprintf(l.w, "\n[output truncated at %d bytes by policy]", l.limit)
+		return len(p), nil
+	}
+	return l.w.Write(p)
+}
+
+// Reset empties the underlying writer and starts the limit over.
+func (l *limitWriter) Reset() error {
+	atomic.StoreInt64(&l.n, 0)
+	return resetWriter(l.w)
+}
+
+// CheckNamespacePolicy returns an error when ns is denied or not allowed.
+func CheckNamespacePolicy(ns string) error {
+	if pattern, ok := matchNamespace(*deniedNamespaces, ns); ok {
+		return fmt.Errorf("namespace %s is denied by -denied-namespaces %s", ns, pattern)
+	}
+	if pattern, ok := matchNamespace(strings.Join(policy.DeniedNamespaces, ","), ns); ok {
+		return fmt.Errorf("namespace %s is denied by policy %s, pattern %s", ns, policyFile, pattern)
+	}
+	if *allowedNamespaces != "" {
+		if _, ok := matchNamespace(*allowedNamespaces, ns); !ok {
+			return fmt.Errorf("namespace %s is not in -allowed-namespaces", ns)
+		}
+	}
+	if len(policy.AllowedNamespaces) > 0 {
+		if _, ok := matchNamespace(strings.Join(policy.AllowedNamespaces, ","), ns); !ok {
+			return fmt.Errorf("namespace %s is not allowed by policy %s", ns, policyFile)
+		}
+	}
+	return nil
+}
+
+func matchNamespace(patterns string, ns string) (string, bool) {
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, ns); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// PostCondition is checked in the target pod after the command succeeded, exactly one of
+// its fields is set. A failed post-condition fails the run.
+type PostCondition struct {
+	File       *FileCondition       `json:"file,omitempty"`
+	Annotation *AnnotationCondition `json:"annotation,omitempty"`
+	Metric     *MetricCondition     `json:"metric,omitempty"`
+}
+
+// FileCondition requires the file to exist in the container with at least MinSize bytes.
+type FileCondition struct {
+	Path    string `json:"path"`
+	MinSize int64  `json:"minSize,omitempty"`
+}
+
+// AnnotationCondition requires the annotation on the pod, with Value when it is set.
+type AnnotationCondition struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// MetricCondition requires a sample of the prometheus metrics served at URL, fetched with
+// curl or wget in the container, to lie between Min and Max. Name is the series as
+// exposed, with its labels when it has any, e.g. backup_last_size_bytes{db="orders"}.
+type MetricCondition struct {
+	URL  string   `json:"url"`
+	Name string   `json:"name"`
+	Min  *float64 `json:"min,omitempty"`
+	Max  *float64 `json:"max,omitempty"`
+}
+
+type PostConditionResult struct {
+	Condition string `json:"condition"`
+	Observed  string `json:"observed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func validatePostConditions(cfgErr *ConfigError, field string, conditions []PostCondition) {
+	for i, c := range conditions {
+		field := fmt.Sprintf("%s.postConditions[%d]", field, i)
+		set := 0
+		if c.File != nil {
+			set++
+			if c.File.Path == "" {
+				cfgErr.Add(field, "file needs a path")
+			}
+			if c.File.MinSize < 0 {
+				cfgErr.Add(field, "file minSize must not be negative")
+			}
+		}
+		if c.Annotation != nil {
+			set++
+			if c.Annotation.Key == "" {
+				cfgErr.Add(field, "annotation needs a key")
+			}
+		}
+		if c.Metric != nil {
+			set++
+			if _, _, err := parseHealthHTTP(c.Metric.URL); err != nil || c.Metric.URL == "" {
+				cfgErr.Add(field, "metric needs a url like :9100/metrics")
+			}
+			if c.Metric.Name == "" {
+				cfgErr.Add(field, "metric needs a name")
+			}
+			if c.Metric.Min == nil && c.Metric.Max == nil {
+				cfgErr.Add(field, "metric needs min, max or both")
+			} else if c.Metric.Min != nil && c.Metric.Max != nil && *c.Metric.Min > *c.Metric.Max {
+				cfgErr.Add(field, "metric min is greater than max")
+			}
+		}
+		if set != 1 {
+			cfgErr.Add(field, "exactly one of file, annotation or metric is required")
+		}
+	}
+}
+
+// String describes the condition for the result.
+func (c *PostCondition) String() string {
+	switch {
+	case c.File != nil:
+		return fmt.Sprintf("file %s has at least %d bytes", c.File.Path, c.File.MinSize)
+	case c.Annotation != nil && c.Annotation.Value != "":
+		return fmt.Sprintf("annotation %s is %q", c.Annotation.Key, c.Annotation.Value)
+	case c.Annotation != nil:
+		return fmt.Sprintf("annotation %s is set", c.Annotation.Key)
+	}
+	s := fmt.Sprintf("metric %s", c.Metric.Name)
+	if c.Metric.Min != nil {
+		s += fmt.Sprintf(" >= %v", *c.Metric.Min)
+	}
+	if c.Metric.Max != nil {
+		s += fmt.Sprintf(" <= %v", *c.Metric.Max)
+	}
+	return s
+}
+
+// CheckPostConditions checks every post-condition of the target in order.
+func CheckPostConditions(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, target *Target, podName string, containerName string) ([]*PostConditionResult, error) {
+	var results []*PostConditionResult
+	var failed []string
+	for i := range target.PostConditions {
+		c := &target.PostConditions[i]
+		result := &PostConditionResult{
+			Condition: c.String(),
+		}
+		var err error
+		switch {
+		case c.File != nil:
+			result.Observed, err = checkFileCondition(ctx, clientset, config, target.Namespace, podName, containerName, c.File)
+		case c.Annotation != nil:
+			result.Observed, err = checkAnnotationCondition(ctx, clientset, target.Namespace, podName, c.Annotation)
+		default:
+			result.Observed, err = checkMetricCondition(ctx, clientset, config, target.Namespace, podName, containerName, c.Metric)
+		}
+		if err != nil {
+			result.Error = err.Error()
+			failed = append(failed, result.Condition)
+		}
+		results = append(results, result)
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("post-condition failed: %s", strings.Join(failed, "; "))
+	}
+	return results, nil
+}
+
+func checkFileCondition(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, namespace string, podName string, containerName string, c *FileCondition) (string, error) {
+	script := fmt.Sprintf("wc -c < %s", shellQuote(c.Path))
+	if *targetOS == OSWindows {
+		script = fmt.Sprintf("for %%I in (%s) do @echo %%~zI", shellQuote(c.Path))
+	}
+	args, _ := ShellArgs(DefaultShell(), script)
+	stdout, stderr, err := ExecInPod(ctx, clientset, config, namespace, podName, containerName, args)
+	size, parseErr := strconv.ParseInt(strings.TrimSpace(stdout), 10, 64)
+	if err != nil || parseErr != nil {
+		if stderr == "" {
+			stderr = "file not found"
+		}
+		return "", fmt.Errorf("%s", strings.TrimSpace(stderr))
+	}
+	observed := fmt.Sprintf("%d bytes", size)
+	if size < c.MinSize {
+		return observed, fmt.Errorf("file has %d bytes, want at least %d", size, c.MinSize)
+	}
+	return observed, nil
+}
+
+func checkAnnotationCondition(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string, c *AnnotationCondition) (string, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, v1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("get pod error: %v", err)
+	}
+	value, ok := pod.Annotations[c.Key]
+	if !ok {
+		return "", fmt.Errorf("annotation is not set")
+	}
+	if c.Value != "" && value != c.Value {
+		return value, fmt.Errorf("annotation is %q", value)
+	}
+	return value, nil
+}
+
+func checkMetricCondition(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, namespace string, podName string, containerName string, c *MetricCondition) (string, error) {
+	_, url, err := parseHealthHTTP(c.URL)
+	if err != nil {
+		return "", err
+	}
+	script := fmt.Sprintf("curl.exe -fsS %s", url)
+	if *targetOS != OSWindows {
+		url = shellQuote(url)
+		script = fmt.Sprintf("if command -v curl >/dev/null; then curl -fsS %s; else wget -q -O - %s; fi", url, url)
+	}
+	args, _ := ShellArgs(DefaultShell(), script)
+	stdout, stderr, err := ExecInPod(ctx, clientset, config, namespace, podName, containerName, args)
+	if err != nil {
+		if stderr != "" {
+			err = fmt.Errorf("%s", strings.TrimSpace(stderr))
+		}
+		return "", fmt.Errorf("fetch metrics error: %v", err)
+	}
+	value, ok := metricSample(stdout, c.Name)
+	if !ok {
+		return "", fmt.Errorf("no sample of %s", c.Name)
+	}
+	observed := strconv.FormatFloat(value, 'g', -1, 64)
+	if (c.Min != nil && value < *c.Min) || (c.Max != nil && value > *c.Max) {
+		return observed, fmt.Errorf("%s is %s, out of range", c.Name, observed)
+	}
+	return observed, nil
+}
+
+// metricSample finds the value of the series in the prometheus text format.
+func metricSample(text string, series string) (float64, bool) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// label values may contain spaces, the series ends with the closing brace.
+		end := strings.LastIndex(line, "}") + 1
+		if end == 0 {
+			end = strings.IndexAny(line, " \t")
+		}
+		if end <= 0 || line[:end] != series {
+			continue
+		}
+		fields := strings.Fields(line[end:])
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		return value, true
+	}
+	return 0, false
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	postDeploy         = flag.String("post-deploy", "", "deployment/name, statefulset/name or daemonset/name: wait for its rollout, run the command in one of its ready pods and write a short message to -termination-log, for helm hooks and argo cd PostSync")
+	rolloutTimeout     = flag.Duration("rollout-timeout", 10*time.Minute, "how long -post-deploy waits for the rollout")
+	terminationLogPath = flag.String("termination-log", "/dev/termination-log", "file the -post-deploy message is written to, shown by kubectl describe and argo cd")
+)
+
+func validatePostDeploy(cfgErr *ConfigError) {
+	if *postDeploy == "" {
+		return
+	}
+	if kind, name := splitWorkloadRef(*postDeploy); name == "" || (kind != "deployment" && kind != "statefulset" && kind != "daemonset") {
+		cfgErr.Add("post-deploy", "must be deployment/<name>, statefulset/<name> or daemonset/<name>")
+	}
+	if *allPods {
+		cfgErr.Add("post-deploy", "conflicts with -all")
+	}
+	if *rolloutTimeout <= 0 {
+		cfgErr.Add("rollout-timeout", "must be positive")
+	}
+}
+
+func splitWorkloadRef(ref string) (string, string) {
+	i := strings.Index(ref, "/")
+	if i < 0 {
+		return "", ""
+	}
+	return strings.ToLower(ref[:i]), ref[i+1:]
+}
+
+// RunPostDeploy waits for the rollout of -post-deploy and runs the command in a ready pod of it.
+func RunPostDeploy(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, cmd []string) *Response {
+	kind, name := splitWorkloadRef(*postDeploy)
+	target := FlagsTarget(cmd)
+	target.Name = *postDeploy
+	selector, err := WaitRollout(ctx, clientset, target.Namespace, kind, name, *rolloutTimeout)
+	if err != nil {
+		return &Response{
+			Target: target.Name,
+			Error:  err,
+		}
+	}
+	target.Labels = joinLabelSelectors(selector, target.Labels)
+	pod, err := readyPod(ctx, clientset, &target)
+	if err != nil {
+		return &Response{
+			Target: target.Name,
+			Error:  err,
+		}
+	}
+	target.PodName, target.Labels = pod, ""
+	EmitEvent(EventPodSelected, map[string]interface{}{
+		"target":    target.Name,
+		"namespace": target.Namespace,
+		"pod":       pod,
+	})
+	resp := RunInPod(ctx, clientset, config, &target, pod, nil)
+	resp.Target = target.Name
+	return resp
+}
+
+// WaitRollout waits until every pod of the workload runs its latest spec and is available,
+// like kubectl rollout status, and returns the pod selector of the workload.
+func WaitRollout(ctx context.Context, clientset *kubernetes.Clientset, namespace string, kind string, name string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		selector, done, err := rolloutStatus(ctx, clientset, namespace, kind, name)
+		if err != nil || done {
+			return selector, err
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("rollout of %s/%s not finished after %s", kind, name, timeout)
+		case <-time.After(*pollInterval):
+		}
+	}
+}
+
+func rolloutStatus(ctx context.Context, clientset *kubernetes.Clientset, namespace string, kind string, name string) (string, bool, error) {
+	switch kind {
+	case "deployment":
+		d, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return "", false, fmt.Errorf("get deployment error: %v", err)
+		}
+		for _, c := range d.Status.Conditions {
+			if c.Type == "Progressing" && c.Reason == "ProgressDeadlineExceeded" {
+				return "", false, fmt.Errorf("rollout of deployment/%s failed: %s", name, c.Message)
+			}
+		}
+		replicas := int32(1)
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+		done := d.Status.ObservedGeneration >= d.Generation &&
+			d.Status.UpdatedReplicas == replicas &&
+			d.Status.Replicas == d.Status.UpdatedReplicas &&
+			d.Status.AvailableReplicas == d.Status.UpdatedReplicas
+		return v1.FormatLabelSelector(d.Spec.Selector), done, nil
+	case "statefulset":
+		s, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return "", false, fmt.Errorf("get statefulset error: %v", err)
+		}
+		replicas := int32(1)
+		if s.Spec.Replicas != nil {
+			replicas = *s.Spec.Replicas
+		}
+		done := s.Status.ObservedGeneration >= s.Generation &&
+			s.Status.UpdatedReplicas == replicas &&
+			s.Status.ReadyReplicas == replicas &&
+			s.Status.CurrentRevision == s.Status.UpdateRevision
+		return v1.FormatLabelSelector(s.Spec.Selector), done, nil
+	default:
+		ds, err := clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return "", false, fmt.Errorf("get daemonset error: %v", err)
+		}
+		done := ds.Status.ObservedGeneration >= ds.Generation &&
+			ds.Status.UpdatedNumberScheduled == ds.Status.DesiredNumberScheduled &&
+			ds.Status.NumberAvailable == ds.Status.DesiredNumberScheduled
+		return v1.FormatLabelSelector(ds.Spec.Selector), done, nil
+	}
+}
+
+// readyPod picks a ready pod that is not terminating and satisfies -wait-for and -where.
+func readyPod(ctx context.Context, clientset *kubernetes.Clientset, target *Target) (string, error) {
+	pods, err := ListCandidatePods(ctx, clientset, target.Namespace, target.Labels, target.FieldSelector(), "")
+	if err != nil {
+		return "", fmt.Errorf("list pods error: %v", err)
+	}
+	ready := make([]corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if pod.DeletionTimestamp == nil && podReady(&pod) {
+			ready = append(ready, pod)
+		}
+	}
+	pod := PickPod(ready)
+	if pod == nil {
+		return "", fmt.Errorf("no ready pod of %s", target.Name)
+	}
+	return pod.Name, nil
+}
+
+func joinLabelSelectors(a string, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + "," + b
+}
+
+// WriteTerminationMessage writes a one line summary of the -post-deploy run to -termination-log.
+func WriteTerminationMessage(resp *Response) {
+	msg := fmt.Sprintf("%s: verification passed in %s (%s)", resp.Target, resp.Pod, resp.Duration)
+	if resp.SkipReason != "" {
+		msg = fmt.Sprintf("%s: verification skipped in %s: %s", resp.Target, resp.Pod, resp.SkipReason)
+	} else if resp.Error != nil {
+		msg = fmt.Sprintf("%s: verification failed", resp.Target)
+		if resp.Pod != "" {
+			msg += " in " + resp.Pod
+		}
+		msg += ": " + resp.Error.Error()
+	}
+	// kubernetes keeps at most 4096 bytes of the message.
+	if len(msg) > 4000 {
+		msg = msg[:3997] + "..."
+	}
+	if err := ioutil.WriteFile(*terminationLogPath, []byte(msg), 0644); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "write termination log error: %v\n", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// profilesDir holds the command profiles, like policyFile it is only set at build time with
+// -ldflags "-X main.profilesDir=/path".
+var profilesDir = "/etc/k8s-cronjob/profiles.d"
+
+var profileName = flag.String("profile", "", "name of a pre-approved command profile in "+profilesDir+" to run, the target and command come from it")
+
+// Profile is a pre-approved target and command, one per file of profilesDir.
+type Profile struct {
+	// Target of the profile, its name defaults to the file name without extension.
+	Target `json:",inline"`
+	// ExecTimeout caps -exec-timeout for the profile, e.g. 1h.
+	ExecTimeout string `json:"execTimeout,omitempty"`
+	// MaxOutputBytes caps stdout and stderr like the policy file.
+	MaxOutputBytes int64 `json:"maxOutputBytes,omitempty"`
+}
+
+// profileTargetFlags select the target, a profile fixes them.
+var profileTargetFlags = []string{"pn", "l", "pod-ip", "node-name", "exclude-label", "cn", "ns", "f", "jobs", "post-deploy", "service", "job", "workload", "run", "command"}
+
+// LoadProfiles reads every *.yaml and *.yml file of profilesDir.
+func LoadProfiles() ([]*Profile, error) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(profilesDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	var profiles []*Profile
+	seen := map[string]string{}
+	for _, file := range files {
+		profile := &Profile{}
+		if err := loadTemplate(file, profile); err != nil {
+			return nil, fmt.Errorf("profile %s: %v", file, err)
+		}
+		if profile.Name == "" {
+			profile.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		}
+		if profile.Namespace == "" {
+			profile.Namespace = "default"
+		}
+		if other, ok := seen[profile.Name]; ok {
+			return nil, fmt.Errorf("profile %q is defined by %s and %s", profile.Name, other, file)
+		}
+		seen[profile.Name] = file
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// RunProfile runs the -profile target, the command line may not change what it runs.
+func RunProfile(cmd []string) {
+	cfgErr := &ConfigError{}
+	if len(cmd) > 0 {
+		cfgErr.Add("profile", "the command comes from the profile, do not pass one")
+	}
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range profileTargetFlags {
+			if f.Name == name {
+				cfgErr.Add(name, "conflicts with -profile, the profile selects the target")
+			}
+		}
+	})
+	if err := cfgErr.ErrOrNil(); err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	profiles, err := LoadProfiles()
+	if err != nil {
+		cfgErr.Add("profile", "%v", err)
+		SendError(&Response{
+			Error: cfgErr,
+		})
+	}
+	var profile *Profile
+	var names []string
+	for _, p := range profiles {
+		names = append(names, p.Name)
+		if p.Name == *profileName {
+			profile = p
+		}
+	}
+	if profile == nil {
+		cfgErr.Add("profile", "unknown profile %q, %s has %s", *profileName, profilesDir, strings.Join(names, ", "))
+		SendError(&Response{
+			Error: cfgErr,
+		})
+	}
+	if err := applyProfileLimits(profile); err != nil {
+		cfgErr.Add("profile", "%v", err)
+		SendError(&Response{
+			Error: cfgErr,
+		})
+	}
+	runConfig(&Config{
+		Targets: []Target{profile.Target},
+	})
+}
+
+// applyProfileLimits tightens -exec-timeout and the output cap to the limits of the profile.
+func applyProfileLimits(profile *Profile) error {
+	if profile.ExecTimeout != "" {
+		timeout, err := time.ParseDuration(profile.ExecTimeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("profile %s: execTimeout must be a positive duration", profile.Name)
+		}
+		if *execTimeout <= 0 || *execTimeout > timeout {
+			*execTimeout = timeout
+		}
+	}
+	if profile.MaxOutputBytes < 0 {
+		return fmt.Errorf("profile %s: maxOutputBytes must not be negative", profile.Name)
+	}
+	if profile.MaxOutputBytes > 0 && (policy.MaxOutputBytes == 0 || policy.MaxOutputBytes > profile.MaxOutputBytes) {
+		policy.MaxOutputBytes = profile.MaxOutputBytes
+	}
+	return nil
+}
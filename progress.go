@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often the -status-file is rewritten while the command runs.
+const progressInterval = time.Second
+
+const (
+	PhaseLookup   = "lookup"
+	PhaseSelected = "selected"
+	PhaseRunning  = "running"
+	PhaseFinished = "finished"
+)
+
+var statusFile = flag.String("status-file", "", "keep the progress of the run in this json file, e.g. /run/k8s-cronjob/status.json on an emptyDir shared with sidecars: phase, target, pod, bytes streamed and elapsed time, rewritten every second")
+
+// progress is the state written to -status-file.
+var progress = struct {
+	mu        sync.Mutex
+	once      sync.Once
+	start     time.Time
+	phase     string
+	target    string
+	namespace string
+	pod       string
+	container string
+	status    string
+	failed    bool
+}{
+	start: time.Now(),
+}
+
+// progressStdout and progressStderr count the bytes streamed with -status-file or
+// -usage-webhook, apart from the progress struct for 64 bit atomic alignment.
+var progressStdout, progressStderr int64
+
+// updateProgress follows the run through the events also printed by -events-ndjson.
+func updateProgress(event string, fields map[string]interface{}) {
+	if *statusFile == "" && *usageWebhook == "" {
+		return
+	}
+	phases := map[string]string{
+		EventLookupStarted: PhaseLookup,
+		EventPodSelected:   PhaseSelected,
+		EventExecStarted:   PhaseRunning,
+	}
+	phase, ok := phases[event]
+	if !ok {
+		return
+	}
+	progress.mu.Lock()
+	progress.phase = phase
+	for name, value := range fields {
+		s, _ := value.(string)
+		switch name {
+		case "target":
+			progress.target = s
+		case "namespace":
+			progress.namespace = s
+		case "pod":
+			progress.pod = s
+		case "container":
+			progress.container = s
+		}
+	}
+	progress.mu.Unlock()
+	if *statusFile == "" {
+		return
+	}
+	progress.once.Do(func() {
+		go func() {
+			for range time.Tick(progressInterval) {
+				writeProgress()
+			}
+		}()
+	})
+	writeProgress()
+}
+
+// FinishProgress writes the final state with the status of the run.
+func FinishProgress(resp *Response) {
+	if *statusFile == "" {
+		return
+	}
+	progress.mu.Lock()
+	progress.phase = PhaseFinished
+	progress.status = resp.Status()
+	if resp.Pod != "" {
+		progress.pod = resp.Pod
+	}
+	progress.mu.Unlock()
+	writeProgress()
+}
+
+// writeProgress replaces -status-file through a rename, so readers never see a partial file.
+func writeProgress() {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	stdout, stderr := atomic.LoadInt64(&progressStdout), atomic.LoadInt64(&progressStderr)
+	status := map[string]interface{}{
+		"phase":          progress.phase,
+		"target":         progress.target,
+		"namespace":      progress.namespace,
+		"pod":            progress.pod,
+		"container":      progress.container,
+		"stdoutBytes":    stdout,
+		"stderrBytes":    stderr,
+		"bytesStreamed":  stdout + stderr,
+		"started":        progress.start.UTC().Format(time.RFC3339),
+		"elapsedSeconds": time.Since(progress.start).Seconds(),
+		"updated":        time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if progress.status != "" {
+		status["status"] = progress.status
+	}
+	b, _ := json.Marshal(status)
+	err := os.MkdirAll(filepath.Dir(*statusFile), 0755)
+	if err == nil {
+		tmp := *statusFile + ".tmp"
+		if err = ioutil.WriteFile(tmp, append(b, '\n'), 0644); err == nil {
+			err = os.Rename(tmp, *statusFile)
+		}
+	}
+	if err != nil && !progress.failed {
+		// reported once, the run goes on without it.
+		progress.failed = true
+		fmt.Fprintf(os.Stderr, "write status file error: %v\n", err)
+	}
+}
+
+// progressWriter counts the bytes of a stream for -status-file and -usage-webhook.
+type progressWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	atomic.AddInt64(p.n, int64(len(b)))
+	return p.w.Write(b)
+}
+
+// Reset passes a restart of the stream on to the wrapped writer, the bytes streamed stay counted.
+func (p *progressWriter) Reset() error {
+	return resetWriter(p.w)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+	"k8s.io/client-go/rest"
+)
+
+var proxyURL = flag.String("proxy-url", "", "http://, https:// (CONNECT) or socks5:// proxy used to reach the api server")
+
+func parseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing proxy host")
+	}
+	return u, nil
+}
+
+// ApplyProxy routes api requests and exec streams of config through -proxy-url.
+// The exec stream only speaks CONNECT, so a socks5 proxy is fronted by a local
+// CONNECT bridge.
+func ApplyProxy(config *rest.Config) error {
+	if *proxyURL == "" {
+		return nil
+	}
+	u, err := parseProxyURL(*proxyURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "socks5" || u.Scheme == "socks5h" {
+		if u, err = startSocksBridge(u); err != nil {
+			return fmt.Errorf("start socks5 bridge error: %v", err)
+		}
+	}
+	config.Proxy = http.ProxyURL(u)
+	return nil
+}
+
+// startSocksBridge serves CONNECT requests on a loopback port by dialing through the socks5 proxy.
+func startSocksBridge(socksURL *url.URL) (*url.URL, error) {
+	var auth *proxy.Auth
+	if socksURL.User != nil {
+		password, _ := socksURL.User.Password()
+		auth = &proxy.Auth{
+			User:     socksURL.User.Username(),
+			Password: password,
+		}
+	}
+	dialer, err := proxy.SOCKS5("tcp", socksURL.Host, auth, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	return startConnectBridge(dialer.Dial)
+}
+
+// startConnectBridge serves CONNECT requests on a loopback port by dialing with dial.
+func startConnectBridge(dial func(network string, addr string) (net.Conn, error)) (*url.URL, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			upstream.Close()
+			http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+			return
+		}
+		conn, buf, err := hijacker.Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+			conn.Close()
+			upstream.Close()
+			return
+		}
+		go func() {
+			io.Copy(upstream, buf)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	return &url.URL{Scheme: "http", Host: l.Addr().String()}, nil
+}
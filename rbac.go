@@ -0,0 +1,508 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const rbacName = "k8s-cronjob"
+
+var (
+	serviceAccount          = flag.String("sa", "", "service account of the runner for the rbac subcommand, default cronjob")
+	serviceAccountNamespace = flag.String("sa-ns", "", "namespace of -sa, default default")
+)
+
+// RunRBAC prints the roles and role bindings the runner needs for the targets of -f,
+// or for the target given by flags.
+func RunRBAC() {
+	cfg := &Config{}
+	if *configFile != "" {
+		var err error
+		if cfg, err = LoadConfig(*configFile); err != nil {
+			cfgErr := &ConfigError{}
+			cfgErr.Add("f", "%v", err)
+			SendError(&Response{
+				Error: cfgErr,
+			})
+		}
+	} else {
+		if err := ValidateTargetFlags(); err != nil {
+			SendError(&Response{
+				Error: err,
+			})
+		}
+		cfg.Targets = []Target{FlagsTarget(nil)}
+	}
+	// the leases of -global-semaphore are granted in -global-semaphore-ns only.
+	semaphoreErr := &ConfigError{}
+	validateGlobalSemaphore(semaphoreErr)
+	if err := semaphoreErr.ErrOrNil(); err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	if *serviceAccount != "" {
+		cfg.ServiceAccount = *serviceAccount
+	}
+	if *serviceAccountNamespace != "" {
+		cfg.ServiceAccountNamespace = *serviceAccountNamespace
+	}
+	if cfg.ServiceAccount == "" {
+		cfg.ServiceAccount = "cronjob"
+	}
+	if cfg.ServiceAccountNamespace == "" {
+		cfg.ServiceAccountNamespace = "default"
+	}
+	for i, obj := range BuildRBAC(cfg) {
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			SendError(&Response{
+				Error: err,
+			})
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		fmt.Print(string(b))
+	}
+}
+
+// BuildRBAC returns one Role and RoleBinding per target namespace, plus a ClusterRole and
+// ClusterRoleBinding for reading nodes with -prefer-zone or -same-zone-as.
+func BuildRBAC(cfg *Config) []interface{} {
+	rules := map[string][]rbacv1.PolicyRule{}
+	var namespaces []string
+	for _, target := range cfg.Targets {
+		if _, ok := rules[target.Namespace]; !ok {
+			namespaces = append(namespaces, target.Namespace)
+			rules[target.Namespace] = nil
+		}
+		if *cooldown > 0 {
+			// the runner claims the cooldown itself, also for impersonated targets.
+			rules[target.Namespace] = appendLeaseRule(rules[target.Namespace])
+		}
+		if *gcInterval > 0 {
+			rules[target.Namespace] = appendGCRule(rules[target.Namespace])
+		}
+		for _, ref := range pauseFrom {
+			// the runner checks the pause itself, also for impersonated targets.
+			rules[target.Namespace] = appendPauseRule(rules[target.Namespace], ref)
+		}
+		if target.ServiceAccount != "" {
+			// everything else is done as the impersonated service account.
+			rules[target.Namespace] = appendImpersonateRule(rules[target.Namespace], target.ServiceAccount)
+			continue
+		}
+		rules[target.Namespace] = appendPodRules(rules[target.Namespace])
+		if !isWorkloadAction() {
+			// a failed pod lookup is diagnosed with the namespace and the events of pending pods.
+			rules[target.Namespace] = appendDiagnoseRules(rules[target.Namespace], target.Namespace)
+		}
+		if target.AnnotatePod {
+			rules[target.Namespace] = appendPodPatchRule(rules[target.Namespace])
+		}
+		if *preferIdle {
+			rules[target.Namespace] = appendPodMetricsRule(rules[target.Namespace], "list")
+		}
+		if *sampleUsage > 0 {
+			rules[target.Namespace] = appendPodMetricsRule(rules[target.Namespace], "get")
+		}
+		if *postDeploy != "" {
+			rules[target.Namespace] = appendWorkloadRule(rules[target.Namespace], *postDeploy)
+		}
+		if *service != "" {
+			rules[target.Namespace] = appendEndpointsRules(rules[target.Namespace])
+		}
+		if *statefulSet != "" {
+			rules[target.Namespace] = appendWorkloadRule(rules[target.Namespace], "statefulset/"+*statefulSet)
+		}
+		if *jobName != "" {
+			rules[target.Namespace] = appendJobTargetRule(rules[target.Namespace])
+		}
+		if *action == ActionLogs || (*jobName != "" && *allowCompleted) {
+			rules[target.Namespace] = appendPodLogRule(rules[target.Namespace])
+		}
+		if *action == ActionTCPCheck {
+			rules[target.Namespace] = appendPortForwardRule(rules[target.Namespace])
+		}
+		if *action == ActionRestart {
+			rules[target.Namespace] = appendPodDeleteRule(rules[target.Namespace])
+		}
+		if isWorkloadAction() {
+			rules[target.Namespace] = appendWorkloadPatchRule(rules[target.Namespace], *workload)
+		}
+		if target.HistoryConfigMap != "" {
+			rules[target.Namespace] = appendConfigMapRule(rules[target.Namespace], target.HistoryConfigMap)
+		}
+	}
+	if *globalSemaphore != "" && *globalSemaphoreNS != "" {
+		if _, ok := rules[*globalSemaphoreNS]; !ok {
+			namespaces = append(namespaces, *globalSemaphoreNS)
+		}
+		rules[*globalSemaphoreNS] = appendLeaseRule(rules[*globalSemaphoreNS])
+		if *gcInterval > 0 {
+			rules[*globalSemaphoreNS] = appendGCRule(rules[*globalSemaphoreNS])
+		}
+	}
+	if ns := cfg.ServiceAccountNamespace; ns != "" {
+		// the runner reads its own pod and job to report where it ran and for -deadline-margin.
+		if _, ok := rules[ns]; !ok {
+			namespaces = append(namespaces, ns)
+		}
+		rules[ns] = appendRunnerJobRules(rules[ns], *annotateJob)
+	}
+	sort.Strings(namespaces)
+	var objs []interface{}
+	for _, ns := range namespaces {
+		objs = append(objs, &rbacv1.Role{
+			TypeMeta: v1.TypeMeta{
+				APIVersion: "rbac.authorization.k8s.io/v1",
+				Kind:       "Role",
+			},
+			ObjectMeta: v1.ObjectMeta{
+				Name:      rbacName,
+				Namespace: ns,
+			},
+			Rules: rules[ns],
+		}, &rbacv1.RoleBinding{
+			TypeMeta: v1.TypeMeta{
+				APIVersion: "rbac.authorization.k8s.io/v1",
+				Kind:       "RoleBinding",
+			},
+			ObjectMeta: v1.ObjectMeta{
+				Name:      rbacName,
+				Namespace: ns,
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "Role",
+				Name:     rbacName,
+			},
+			Subjects: []rbacv1.Subject{
+				{
+					Kind:      "ServiceAccount",
+					Name:      cfg.ServiceAccount,
+					Namespace: cfg.ServiceAccountNamespace,
+				},
+			},
+		})
+	}
+	if *preferZone != "" || *sameZoneAs != "" {
+		// nodes are cluster scoped, the zone of a pod is the label of its node.
+		objs = append(objs, &rbacv1.ClusterRole{
+			TypeMeta: v1.TypeMeta{
+				APIVersion: "rbac.authorization.k8s.io/v1",
+				Kind:       "ClusterRole",
+			},
+			ObjectMeta: v1.ObjectMeta{
+				Name: rbacName,
+			},
+			Rules: []rbacv1.PolicyRule{
+				{
+					APIGroups: []string{""},
+					Resources: []string{"nodes"},
+					Verbs:     []string{"get"},
+				},
+			},
+		}, &rbacv1.ClusterRoleBinding{
+			TypeMeta: v1.TypeMeta{
+				APIVersion: "rbac.authorization.k8s.io/v1",
+				Kind:       "ClusterRoleBinding",
+			},
+			ObjectMeta: v1.ObjectMeta{
+				Name: rbacName,
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "ClusterRole",
+				Name:     rbacName,
+			},
+			Subjects: []rbacv1.Subject{
+				{
+					Kind:      "ServiceAccount",
+					Name:      cfg.ServiceAccount,
+					Namespace: cfg.ServiceAccountNamespace,
+				},
+			},
+		})
+	}
+	return objs
+}
+
+func appendPodRules(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	for _, rule := range rules {
+		if len(rule.Resources) == 1 && rule.Resources[0] == "pods/exec" {
+			return rules
+		}
+	}
+	return append(rules, rbacv1.PolicyRule{
+		APIGroups: []string{""},
+		Resources: []string{"pods"},
+		Verbs:     []string{"get", "list", "watch"},
+	}, rbacv1.PolicyRule{
+		APIGroups: []string{""},
+		Resources: []string{"pods/exec"},
+		Verbs:     []string{"create"},
+	})
+}
+
+// appendUniqueRule appends rule unless rules already has the same one, as every target of a
+// namespace asks for its rules again.
+func appendUniqueRule(rules []rbacv1.PolicyRule, rule rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	for _, r := range rules {
+		if reflect.DeepEqual(r, rule) {
+			return rules
+		}
+	}
+	return append(rules, rule)
+}
+
+// appendDiagnoseRules grants DiagnoseLookup reading the namespace itself and listing events.
+func appendDiagnoseRules(rules []rbacv1.PolicyRule, namespace string) []rbacv1.PolicyRule {
+	for _, rule := range rules {
+		if len(rule.Resources) == 1 && rule.Resources[0] == "events" {
+			return rules
+		}
+	}
+	return append(rules, rbacv1.PolicyRule{
+		APIGroups:     []string{""},
+		Resources:     []string{"namespaces"},
+		ResourceNames: []string{namespace},
+		Verbs:         []string{"get"},
+	}, rbacv1.PolicyRule{
+		APIGroups: []string{""},
+		Resources: []string{"events"},
+		Verbs:     []string{"list"},
+	})
+}
+
+// appendImpersonateRule grants impersonating the named service account.
+func appendImpersonateRule(rules []rbacv1.PolicyRule, name string) []rbacv1.PolicyRule {
+	for i := range rules {
+		if len(rules[i].Resources) == 1 && rules[i].Resources[0] == "serviceaccounts" {
+			for _, n := range rules[i].ResourceNames {
+				if n == name {
+					return rules
+				}
+			}
+			rules[i].ResourceNames = append(rules[i].ResourceNames, name)
+			return rules
+		}
+	}
+	return append(rules, rbacv1.PolicyRule{
+		APIGroups:     []string{""},
+		Resources:     []string{"serviceaccounts"},
+		ResourceNames: []string{name},
+		Verbs:         []string{"impersonate"},
+	})
+}
+
+// appendConfigMapRule grants access to the named configmap. Creating can not be limited
+// by name, so create is granted on configmaps in general.
+func appendConfigMapRule(rules []rbacv1.PolicyRule, name string) []rbacv1.PolicyRule {
+	for i := range rules {
+		if len(rules[i].Resources) == 1 && rules[i].Resources[0] == "configmaps" && len(rules[i].ResourceNames) > 0 {
+			for _, n := range rules[i].ResourceNames {
+				if n == name {
+					return rules
+				}
+			}
+			rules[i].ResourceNames = append(rules[i].ResourceNames, name)
+			return rules
+		}
+	}
+	return append(rules, rbacv1.PolicyRule{
+		APIGroups:     []string{""},
+		Resources:     []string{"configmaps"},
+		ResourceNames: []string{name},
+		Verbs:         []string{"get", "update"},
+	}, rbacv1.PolicyRule{
+		APIGroups: []string{""},
+		Resources: []string{"configmaps"},
+		Verbs:     []string{"create"},
+	})
+}
+
+// appendRunnerJobRules grants finding the job of the runner pod, and with patch annotating it.
+func appendRunnerJobRules(rules []rbacv1.PolicyRule, patch bool) []rbacv1.PolicyRule {
+	verbs := []string{"get"}
+	if patch {
+		verbs = append(verbs, "patch")
+	}
+	return append(rules, rbacv1.PolicyRule{
+		APIGroups: []string{""},
+		Resources: []string{"pods"},
+		Verbs:     []string{"get"},
+	}, rbacv1.PolicyRule{
+		APIGroups: []string{"batch"},
+		Resources: []string{"jobs"},
+		Verbs:     verbs,
+	})
+}
+
+// appendPodMetricsRule grants reading the pod metrics of metrics-server with verb.
+func appendPodMetricsRule(rules []rbacv1.PolicyRule, verb string) []rbacv1.PolicyRule {
+	for i := range rules {
+		if rules[i].APIGroups[0] == "metrics.k8s.io" {
+			for _, v := range rules[i].Verbs {
+				if v == verb {
+					return rules
+				}
+			}
+			rules[i].Verbs = append(rules[i].Verbs, verb)
+			return rules
+		}
+	}
+	return append(rules, rbacv1.PolicyRule{
+		APIGroups: []string{"metrics.k8s.io"},
+		Resources: []string{"pods"},
+		Verbs:     []string{verb},
+	})
+}
+
+// appendLeaseRule grants taking -global-semaphore slots and -cooldown windows. Creating can
+// not be limited by name.
+func appendLeaseRule(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	for _, rule := range rules {
+		if len(rule.Resources) == 1 && rule.Resources[0] == "leases" {
+			return rules
+		}
+	}
+	return append(rules, rbacv1.PolicyRule{
+		APIGroups: []string{"coordination.k8s.io"},
+		Resources: []string{"leases"},
+		Verbs:     []string{"get", "create", "update"},
+	})
+}
+
+// appendGCRule grants -gc-interval deleting the leases and ConfigMaps k8s-cronjob left behind.
+func appendGCRule(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	for _, rule := range rules {
+		if len(rule.Verbs) == 2 && rule.Verbs[0] == "list" && rule.Verbs[1] == "delete" {
+			return rules
+		}
+	}
+	return append(rules, rbacv1.PolicyRule{
+		APIGroups: []string{"coordination.k8s.io"},
+		Resources: []string{"leases"},
+		Verbs:     []string{"list", "delete"},
+	}, rbacv1.PolicyRule{
+		APIGroups: []string{""},
+		Resources: []string{"configmaps"},
+		Verbs:     []string{"list", "delete"},
+	})
+}
+
+// appendWorkloadRule grants reading the rollout status of the -post-deploy workload.
+func appendWorkloadRule(rules []rbacv1.PolicyRule, ref string) []rbacv1.PolicyRule {
+	kind, name := splitWorkloadRef(ref)
+	return appendUniqueRule(rules, rbacv1.PolicyRule{
+		APIGroups:     []string{"apps"},
+		Resources:     []string{kind + "s"},
+		ResourceNames: []string{name},
+		Verbs:         []string{"get"},
+	})
+}
+
+// appendPauseRule grants reading the annotations of a -pause-from object.
+func appendPauseRule(rules []rbacv1.PolicyRule, ref string) []rbacv1.PolicyRule {
+	kind, name := splitWorkloadRef(ref)
+	group := ""
+	if kind == "lease" {
+		group = "coordination.k8s.io"
+	}
+	for _, rule := range rules {
+		if rule.APIGroups[0] == group && rule.Resources[0] == kind+"s" && len(rule.ResourceNames) == 1 && rule.ResourceNames[0] == name && rule.Verbs[0] == "get" {
+			return rules
+		}
+	}
+	return append(rules, rbacv1.PolicyRule{
+		APIGroups:     []string{group},
+		Resources:     []string{kind + "s"},
+		ResourceNames: []string{name},
+		Verbs:         []string{"get"},
+	})
+}
+
+// appendEndpointsRules grants reading the endpoints of -service, slices can not be limited by name.
+func appendEndpointsRules(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	for _, rule := range rules {
+		if len(rule.Resources) == 1 && rule.Resources[0] == "endpointslices" {
+			return rules
+		}
+	}
+	name, _ := splitServiceRef(*service)
+	return append(rules, rbacv1.PolicyRule{
+		APIGroups: []string{"discovery.k8s.io"},
+		Resources: []string{"endpointslices"},
+		Verbs:     []string{"list"},
+	}, rbacv1.PolicyRule{
+		APIGroups:     []string{""},
+		Resources:     []string{"endpoints"},
+		ResourceNames: []string{name},
+		Verbs:         []string{"get"},
+	})
+}
+
+// appendJobTargetRule grants reading the -job selector.
+func appendJobTargetRule(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	return appendUniqueRule(rules, rbacv1.PolicyRule{
+		APIGroups:     []string{"batch"},
+		Resources:     []string{"jobs"},
+		ResourceNames: []string{*jobName},
+		Verbs:         []string{"get"},
+	})
+}
+
+func appendPodLogRule(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	return appendUniqueRule(rules, rbacv1.PolicyRule{
+		APIGroups: []string{""},
+		Resources: []string{"pods/log"},
+		Verbs:     []string{"get"},
+	})
+}
+
+func appendPortForwardRule(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	return appendUniqueRule(rules, rbacv1.PolicyRule{
+		APIGroups: []string{""},
+		Resources: []string{"pods/portforward"},
+		Verbs:     []string{"create"},
+	})
+}
+
+func appendPodDeleteRule(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	return appendUniqueRule(rules, rbacv1.PolicyRule{
+		APIGroups: []string{""},
+		Resources: []string{"pods"},
+		Verbs:     []string{"delete"},
+	})
+}
+
+func appendWorkloadPatchRule(rules []rbacv1.PolicyRule, ref string) []rbacv1.PolicyRule {
+	kind, name := splitWorkloadRef(ref)
+	return appendUniqueRule(rules, rbacv1.PolicyRule{
+		APIGroups:     []string{"apps"},
+		Resources:     []string{kind + "s"},
+		ResourceNames: []string{name},
+		Verbs:         []string{"get", "patch"},
+	})
+}
+
+func appendPodPatchRule(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	for _, rule := range rules {
+		if len(rule.Resources) == 1 && rule.Resources[0] == "pods" && len(rule.Verbs) == 1 && rule.Verbs[0] == "patch" {
+			return rules
+		}
+	}
+	return append(rules, rbacv1.PolicyRule{
+		APIGroups: []string{""},
+		Resources: []string{"pods"},
+		Verbs:     []string{"patch"},
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// reattachPoll is the wait between looking at a restarting container.
+const reattachPoll = time.Second
+
+var reattachTimeout = flag.Duration("reattach-timeout", time.Second*30, "when the container restarted between the pod being picked and the exec attaching, wait this long for it to run again and attach again, 0 fails right away")
+
+func validateReattachTimeout(cfgErr *ConfigError) {
+	if *reattachTimeout < 0 {
+		cfgErr.Add("reattach-timeout", "must not be negative")
+	}
+}
+
+// ExecReattaching runs cmd like ExecWithOutputs. When the exec found no container, because
+// it restarted after the pod was picked, the container is waited for and the exec attached
+// again while -reattach-timeout lasts; nothing ran in the container yet.
+func ExecReattaching(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, namespace string, podName string, containerName string, cmd []string, stdin io.Reader) (*ExecOutput, error) {
+	deadline := time.Now().Add(*reattachTimeout)
+	for {
+		out, err := ExecWithOutputs(ctx, clientset, config, namespace, podName, containerName, cmd, stdin)
+		if *reattachTimeout <= 0 || stdin != nil || ctx.Err() != nil || !isContainerNotFound(err) {
+			return out, err
+		}
+		if out.Stdout != "" || out.Stderr != "" || fileWritten(out.StdoutFile) || fileWritten(out.StderrFile) {
+			return out, err
+		}
+		fmt.Fprintf(os.Stderr, "container of pod %s not found, waiting for it to run again: %v\n", podName, err)
+		if waitErr := waitContainerRunning(ctx, clientset, namespace, podName, containerName, deadline); waitErr != nil {
+			return out, fmt.Errorf("%v, %v", err, waitErr)
+		}
+	}
+}
+
+// isContainerNotFound reports whether the kubelet refused the exec because the container
+// is not there, e.g. restarting.
+func isContainerNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "container not found")
+}
+
+// waitContainerRunning refreshes the pod until the container, without a name the first
+// one, is running again. A deleted or stopped pod is not waited for.
+func waitContainerRunning(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string, containerName string, deadline time.Time) error {
+	for {
+		pod, err := getPod(ctx, clientset, namespace, podName)
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("pod %s is gone", podName)
+		}
+		if err != nil {
+			return fmt.Errorf("get pod %s error: %v", podName, err)
+		}
+		if pod.DeletionTimestamp != nil {
+			return fmt.Errorf("pod %s is terminating", podName)
+		}
+		name := containerName
+		if name == "" && len(pod.Spec.Containers) > 0 {
+			name = pod.Spec.Containers[0].Name
+		}
+		state := "not started"
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != name {
+				continue
+			}
+			switch {
+			case status.State.Running != nil:
+				return nil
+			case status.State.Waiting != nil:
+				state = "waiting: " + status.State.Waiting.Reason
+			case status.State.Terminated != nil:
+				state = "terminated: " + status.State.Terminated.Reason
+			}
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("container %s is still %s after %s", name, state, *reattachTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reattachPoll):
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	redactRegexes stringsFlag
+	redactEnv     stringsFlag
+
+	redactOnce     sync.Once
+	redactPatterns []*regexp.Regexp
+)
+
+func init() {
+	flag.Var(&redactRegexes, "redact-regex", "regexp whose matches are replaced in the output before it is logged, stored or sent, repeatable")
+	flag.Var(&redactEnv, "redact-env", "name of an environment variable of the runner whose value is replaced in the output, repeatable")
+}
+
+func compileRedaction() {
+	for _, expr := range redactRegexes {
+		if re, err := regexp.Compile(expr); err == nil {
+			redactPatterns = append(redactPatterns, re)
+		}
+	}
+	for _, name := range redactEnv {
+		if value := os.Getenv(name); value != "" {
+			redactPatterns = append(redactPatterns, regexp.MustCompile(regexp.QuoteMeta(value)))
+		}
+	}
+}
+
+// Redact scrubs -redact-regex matches and -redact-env values from s.
+func Redact(s string) string {
+	redactOnce.Do(compileRedaction)
+	for _, re := range redactPatterns {
+		s = re.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+// RedactArgs returns the redacted arguments of a command.
+func RedactArgs(cmd []string) []string {
+	args := make([]string, len(cmd))
+	for i, arg := range cmd {
+		args[i] = Redact(arg)
+	}
+	return args
+}
+
+// maxRedactLine bounds what lineRedactor holds back waiting for a newline.
+const maxRedactLine = 64 << 10
+
+// lineRedactor redacts streamed output a line at a time, so a secret split across two
+// writes is still matched.
+type lineRedactor struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+// lines takes the next write and returns the redacted complete lines, "" while a line is
+// still open.
+func (l *lineRedactor) lines(p []byte) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	end := len(l.buf)
+	if len(l.buf) < maxRedactLine {
+		end = strings.LastIndexByte(string(l.buf), '\n') + 1
+	}
+	s := string(l.buf[:end])
+	l.buf = append([]byte(nil), l.buf[end:]...)
+	return Redact(s)
+}
+
+// flush returns the redacted rest after the last newline.
+func (l *lineRedactor) flush() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := string(l.buf)
+	l.buf = nil
+	return Redact(s)
+}
+
+// RedactError returns err with its message redacted.
+func RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := Redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return errors.New(msg)
+}
+
+// RedactResponse scrubs the command, verify and post-condition output of resp.
+func RedactResponse(resp *Response) {
+	resp.Stdout = Redact(resp.Stdout)
+	resp.Stderr = Redact(resp.Stderr)
+	resp.Error = RedactError(resp.Error)
+	if resp.Output != nil {
+		resp.Output.Head = Redact(resp.Output.Head)
+		resp.Output.Tail = Redact(resp.Output.Tail)
+	}
+	if resp.Verify != nil {
+		resp.Verify.Stdout = Redact(resp.Verify.Stdout)
+		resp.Verify.Stderr = Redact(resp.Verify.Stderr)
+		resp.Verify.Error = Redact(resp.Verify.Error)
+	}
+	for _, c := range resp.PostConditions {
+		c.Observed = Redact(c.Observed)
+		c.Error = Redact(c.Error)
+	}
+}
+
+func validateRedaction(cfgErr *ConfigError) {
+	for _, expr := range redactRegexes {
+		if _, err := regexp.Compile(expr); err != nil {
+			cfgErr.Add("redact-regex", "malformed regexp %q: %v", expr, err)
+		} else if regexp.MustCompile(expr).MatchString("") {
+			cfgErr.Add("redact-regex", "%q matches the empty string", expr)
+		}
+	}
+	for _, name := range redactEnv {
+		if strings.TrimSpace(name) == "" {
+			cfgErr.Add("redact-env", "empty variable name")
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// maxChurnRetries bounds the re-picks after pod churn when -wp is 0.
+const maxChurnRetries = 3
+
+// SelectAndRun picks the target pod and runs the command in it. A pod terminating between
+// being picked and the exec attaching, e.g. during a rollout, is replaced by a newly picked
+// pod while the -wp window lasts; the replaced pods are listed in ChurnedPods.
+func SelectAndRun(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, target *Target, stdin io.Reader) *Response {
+	start := time.Now()
+	var churned []string
+	for {
+		EmitEvent(EventLookupStarted, map[string]interface{}{
+			"target":    target.Name,
+			"namespace": target.Namespace,
+			"labels":    target.Labels,
+			"fields":    target.FieldSelector(),
+			"pod":       target.PodName,
+		})
+		var podName string
+		var err error
+		if *waitRunningPodTimeout > 0 {
+			remaining := *waitRunningPodTimeout - time.Since(start)
+			if remaining <= 0 {
+				err = fmt.Errorf("lookup running pod timeout")
+			} else {
+				podName, err = LookupRunningPodTimeout(ctx, clientset, target.Namespace, target.Labels, target.FieldSelector(), target.PodName, target.Container, remaining)
+			}
+		} else {
+			podName, err = LookupRunningPod(ctx, clientset, target.Namespace, target.Labels, target.FieldSelector(), target.PodName, target.Container)
+		}
+		if err != nil {
+			return &Response{
+				Error:       fmt.Errorf("lookup running pod error: %v", err),
+				Diagnostics: DiagnoseLookup(clientset, target.Namespace, target.Labels, target.FieldSelector(), target.PodName),
+				ChurnedPods: churned,
+			}
+		}
+		EmitEvent(EventPodSelected, map[string]interface{}{
+			"target":    target.Name,
+			"namespace": target.Namespace,
+			"pod":       podName,
+		})
+		resp := RunInPod(ctx, clientset, config, target, podName, stdin)
+		if !resp.churned {
+			resp.ChurnedPods = churned
+			return resp
+		}
+		churned = append(churned, podName)
+		fmt.Fprintf(os.Stderr, "pod %s terminated before the exec attached, picking another pod\n", podName)
+		if stdin != nil || ctx.Err() != nil || (*waitRunningPodTimeout <= 0 && len(churned) > maxChurnRetries) {
+			resp.ChurnedPods = churned
+			return resp
+		}
+	}
+}
+
+// podGone reports whether the pod was deleted or is no longer running.
+func podGone(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string) bool {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return true
+	}
+	return err == nil && (pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning)
+}
+
+// RunInPod executes the target command in the pod and applies everything that follows a
+// single execution: remote kill on cancellation, verification, pod annotations and history.
+func RunInPod(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, target *Target, podName string, stdin io.Reader) *Response {
+	namespace, containerName, cmd := target.Namespace, target.Container, target.Command
+	start := time.Now()
+	var uid types.UID
+	if *chaosSafe {
+		var err error
+		if uid, err = PodUID(ctx, clientset, namespace, podName); err != nil {
+			return &Response{
+				Pod:   podName,
+				Error: fmt.Errorf("get pod uid error: %v", err),
+			}
+		}
+	}
+	if containerName == "" && *mesh != "" {
+		var err error
+		if containerName, err = MeshContainer(ctx, clientset, namespace, podName); err != nil {
+			return &Response{
+				Pod:   podName,
+				Error: fmt.Errorf("choose container error: %v", err),
+			}
+		}
+	}
+	if *healthHTTP != "" {
+		if err := CheckHealth(ctx, clientset, config, namespace, podName, containerName); err != nil {
+			return &Response{
+				Pod:        podName,
+				SkipReason: fmt.Sprintf("unhealthy: %v", err),
+			}
+		}
+	}
+	if target.ExpectImage != "" {
+		if err := CheckImage(ctx, clientset, namespace, podName, containerName, target.ExpectImage); err != nil {
+			return &Response{
+				Pod:   podName,
+				Error: fmt.Errorf("refusing to run: %v", err),
+			}
+		}
+	}
+	EmitEvent(EventExecStarted, map[string]interface{}{
+		"target":    target.Name,
+		"namespace": namespace,
+		"pod":       podName,
+		"container": containerName,
+	})
+	if *chaosSafe {
+		change, err := PodChange(ctx, clientset, namespace, podName, uid)
+		if err != nil {
+			return &Response{
+				Pod:   podName,
+				Error: fmt.Errorf("check pod identity error: %v", err),
+			}
+		}
+		if change != "" {
+			// nothing ran yet, another pod may be picked.
+			return &Response{
+				Pod:     podName,
+				Error:   fmt.Errorf("pod changed before the exec: %s", change),
+				churned: true,
+			}
+		}
+	}
+	slot, err := AcquireSemaphore(ctx, clientset)
+	if err != nil {
+		return &Response{
+			Pod:   podName,
+			Error: err,
+		}
+	}
+	sampler := StartUsageSampler(ctx, clientset, namespace, podName, containerName)
+	out, err := runPodAction(ctx, clientset, config, target, podName, containerName, uid, stdin)
+	usage := sampler.Stop()
+	slot.Release()
+	var change string
+	if *chaosSafe && ctx.Err() == nil && *action != ActionRestart {
+		var identityErr error
+		if change, identityErr = PodChange(ctx, clientset, namespace, podName, uid); identityErr != nil {
+			fmt.Fprintf(os.Stderr, "check pod identity error: %v\n", identityErr)
+		}
+	}
+	if err != nil && ctx.Err() == nil && *action != ActionRestart && out.Stdout == "" && out.Stderr == "" && out.StdoutFile == "" && out.StderrFile == "" && podGone(ctx, clientset, namespace, podName) {
+		return &Response{
+			Pod:     podName,
+			Error:   fmt.Errorf("pod terminated before the exec attached: %v", err),
+			churned: true,
+		}
+	}
+	if ctx.Err() != nil && *action == ActionExec {
+		KillRemote(clientset, config, namespace, podName, containerName, cmd)
+	}
+	resp := &Response{
+		Pod:        podName,
+		Stdout:     out.Stdout,
+		Stderr:     out.Stderr,
+		StdoutFile: out.StdoutFile,
+		StderrFile: out.StderrFile,
+		Output:     out.Combined,
+		Started:    start,
+		Error:      err,
+		Cancelled:  ctx.Err() != nil,
+		Usage:      usage,
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		resp.PartialOutput = out.Stdout != "" || out.Stderr != "" || fileWritten(out.StdoutFile) || fileWritten(out.StderrFile)
+	}
+	if change != "" {
+		resp.TargetChurned = true
+		resp.Error = fmt.Errorf("the output may not come from the picked pod: %s", change)
+	}
+	if err == nil && *verifyCmd != "" {
+		resp.Verify = RunVerify(ctx, clientset, config, namespace, podName, containerName)
+		if resp.Verify.Error != "" {
+			resp.Error = fmt.Errorf("verify command failed: %s", resp.Verify.Error)
+		}
+	}
+	if resp.Error == nil && len(target.PostConditions) > 0 {
+		resp.PostConditions, resp.Error = CheckPostConditions(ctx, clientset, config, target, podName, containerName)
+	}
+	RedactResponse(resp)
+	if *diagnoseOnFailure && resp.Error != nil && !resp.TargetChurned {
+		resp.FailureDiagnostics = RunFailureDiagnostics(clientset, config, namespace, podName, containerName)
+	}
+	duration := time.Since(start)
+	resp.Duration = duration.String()
+	if target.HistoryConfigMap != "" && *slowThreshold > 0 && resp.Error == nil {
+		// read before recording, so the current run is not part of its own median.
+		durations, err := HistoryDurations(clientset, namespace, target.HistoryConfigMap, cmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read history error: %v\n", err)
+		} else if len(durations) >= minSlowSamples {
+			median := MedianDuration(durations)
+			resp.MedianDuration = median.String()
+			resp.Slow = float64(duration) > float64(median)*(1+float64(*slowThreshold)/100)
+		}
+	}
+	if target.AnnotatePod {
+		if err := AnnotatePod(clientset, namespace, podName, start, resp.Status()); err != nil {
+			fmt.Fprintf(os.Stderr, "annotate pod error: %v\n", err)
+		}
+	}
+	if target.HistoryConfigMap != "" {
+		record := NewHistoryRecord(start, podName, cmd, resp)
+		if err := RecordHistory(clientset, namespace, target.HistoryConfigMap, record, *historyLimit, *historyTTL); err != nil {
+			fmt.Fprintf(os.Stderr, "record history error: %v\n", err)
+		}
+	}
+	if err := RecordResult(target, start, resp); err != nil {
+		fmt.Fprintf(os.Stderr, "record result error: %v\n", err)
+	}
+	if err := SendToSinks(target, start, resp); err != nil {
+		fmt.Fprintf(os.Stderr, "send run record error: %v\n", err)
+	}
+	if err := Attest(target, start, resp); err != nil {
+		fmt.Fprintf(os.Stderr, "attest run error: %v\n", err)
+	}
+	return resp
+}
+
+// fileWritten reports whether the -stdout-file or -stderr-file at path is not empty.
+func fileWritten(path string) bool {
+	if path == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Size() > 0
+}
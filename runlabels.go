@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+var runLabels stringsFlag
+
+func init() {
+	flag.Var(&runLabels, "label", "key=value attached to the result, webhooks and history records, e.g. team=payments, repeatable")
+}
+
+// RunLabels returns the -label pairs, nil when there are none.
+func RunLabels() map[string]string {
+	if len(runLabels) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(runLabels))
+	for _, label := range runLabels {
+		if i := strings.Index(label, "="); i > 0 {
+			m[label[:i]] = label[i+1:]
+		}
+	}
+	return m
+}
+
+func validateRunLabels(cfgErr *ConfigError) {
+	for _, label := range runLabels {
+		if strings.Index(label, "=") <= 0 {
+			cfgErr.Add("label", "malformed label %q, want key=value", label)
+		}
+	}
+}
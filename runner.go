@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RunnerInfo identifies the pod k8s-cronjob runs in and the Job and CronJob that created it,
+// so results trace back to the manifest that started them.
+type RunnerInfo struct {
+	Pod       string `json:"pod"`
+	Namespace string `json:"namespace"`
+	Node      string `json:"node,omitempty"`
+	Job       string `json:"job,omitempty"`
+	CronJob   string `json:"cronJob,omitempty"`
+}
+
+// runner is nil when k8s-cronjob does not run in a pod.
+var runner *RunnerInfo
+
+// ResolveRunner fills in the runner from POD_NAME, POD_NAMESPACE and NODE_NAME, which can be
+// set through the downward api, and the api. The owner chain needs get on pods and jobs of
+// the runner namespace, without it only what the environment tells is known.
+func ResolveRunner(clientset *kubernetes.Clientset) {
+	info := &RunnerInfo{
+		Pod:       os.Getenv("POD_NAME"),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+		Node:      os.Getenv("NODE_NAME"),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	pod, err := RunnerPod(ctx, clientset)
+	if err != nil {
+		if info.Pod != "" {
+			runner = info
+		}
+		warnRunner(err)
+		return
+	}
+	info.Pod, info.Namespace = pod.Name, pod.Namespace
+	if pod.Spec.NodeName != "" {
+		info.Node = pod.Spec.NodeName
+	}
+	runner = info
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind != "Job" {
+			continue
+		}
+		info.Job = owner.Name
+		job, err := clientset.BatchV1().Jobs(pod.Namespace).Get(ctx, owner.Name, v1.GetOptions{})
+		if err != nil {
+			warnRunner(err)
+			return
+		}
+		for _, jobOwner := range job.OwnerReferences {
+			if jobOwner.Kind == "CronJob" {
+				info.CronJob = jobOwner.Name
+			}
+		}
+	}
+}
+
+// warnRunner stays quiet outside a cluster and without the rbac for the owner chain.
+func warnRunner(err error) {
+	if errors.IsForbidden(err) || errors.IsNotFound(err) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "lookup runner pod error: %v\n", err)
+}
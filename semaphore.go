@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// semaphoreLeaseSeconds is how long a slot of a crashed runner stays taken.
+const semaphoreLeaseSeconds = 60
+
+var (
+	globalSemaphore     = flag.String("global-semaphore", "", "name=N, at most N runners in the cluster exec under this name at the same time, each slot is a Lease")
+	globalSemaphoreNS   = flag.String("global-semaphore-ns", "", "namespace of the -global-semaphore leases, required with -global-semaphore so runners of every namespace share them")
+	globalSemaphoreWait = flag.Duration("global-semaphore-wait", 10*time.Minute, "how long to wait for a free -global-semaphore slot")
+)
+
+var semaphoreNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+func validateGlobalSemaphore(cfgErr *ConfigError) {
+	if *globalSemaphore == "" {
+		if *globalSemaphoreNS != "" {
+			cfgErr.Add("global-semaphore-ns", "requires -global-semaphore")
+		}
+		return
+	}
+	if _, _, err := parseSemaphore(*globalSemaphore); err != nil {
+		cfgErr.Add("global-semaphore", "%v", err)
+	}
+	// leases in the target namespace would only limit the runners of that namespace.
+	if *globalSemaphoreNS == "" {
+		cfgErr.Add("global-semaphore-ns", "is required with -global-semaphore, the namespace its leases are shared in")
+	}
+	if *globalSemaphoreWait < 0 {
+		cfgErr.Add("global-semaphore-wait", "must not be negative")
+	}
+}
+
+func parseSemaphore(s string) (string, int, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("want name=N, e.g. shared-db=2")
+	}
+	name := s[:i]
+	n, err := strconv.Atoi(s[i+1:])
+	if err != nil || n < 1 {
+		return "", 0, fmt.Errorf("the count of %q must be a positive number", name)
+	}
+	// the lease names are derived from it.
+	if len(name) > 40 || !semaphoreNamePattern.MatchString(name) {
+		return "", 0, fmt.Errorf("name %q must be at most 40 lowercase letters, digits and -", name)
+	}
+	return name, n, nil
+}
+
+// SemaphoreSlot is a held slot of -global-semaphore, renewed until released.
+type SemaphoreSlot struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	name      string
+	holder    string
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// AcquireSemaphore waits for a free slot of -global-semaphore, nil when it is not set.
+func AcquireSemaphore(ctx context.Context, clientset *kubernetes.Clientset) (*SemaphoreSlot, error) {
+	if *globalSemaphore == "" {
+		return nil, nil
+	}
+	name, n, err := parseSemaphore(*globalSemaphore)
+	if err != nil {
+		return nil, err
+	}
+	namespace := *globalSemaphoreNS
+	runID, err := newRunID()
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	holder := hostname + "/" + runID
+	ctx, cancel := context.WithTimeout(ctx, *globalSemaphoreWait)
+	defer cancel()
+	for {
+		for i := 0; i < n; i++ {
+			leaseName := fmt.Sprintf("k8s-cronjob-semaphore-%s-%d", name, i)
+			ok, err := tryAcquireLease(ctx, clientset, namespace, leaseName, holder)
+			if err != nil {
+				return nil, fmt.Errorf("acquire semaphore %s error: %v", name, err)
+			}
+			if ok {
+				slot := &SemaphoreSlot{
+					clientset: clientset,
+					namespace: namespace,
+					name:      leaseName,
+					holder:    holder,
+					stop:      make(chan struct{}),
+					done:      make(chan struct{}),
+				}
+				go slot.renew()
+				return slot, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no free slot of semaphore %s after %s", name, *globalSemaphoreWait)
+		case <-time.After(*pollInterval):
+		}
+	}
+}
+
+// tryAcquireLease takes the lease when it is missing, released or expired. Concurrent
+// runners race through the resource version, only one update wins.
+func tryAcquireLease(ctx context.Context, clientset *kubernetes.Clientset, namespace string, name string, holder string) (bool, error) {
+	leases := clientset.CoordinationV1().Leases(namespace)
+	now := v1.NewMicroTime(time.Now())
+	duration := int32(semaphoreLeaseSeconds)
+	lease, err := leases.Get(ctx, name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    managedLabels(),
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, v1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	if leaseHeld(lease) {
+		return false, nil
+	}
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, v1.UpdateOptions{})
+	if errors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func leaseHeld(lease *coordinationv1.Lease) bool {
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" || lease.Spec.RenewTime == nil {
+		return false
+	}
+	duration := time.Duration(semaphoreLeaseSeconds) * time.Second
+	if lease.Spec.LeaseDurationSeconds != nil {
+		duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+	return time.Since(lease.Spec.RenewTime.Time) < duration
+}
+
+func (s *SemaphoreSlot) renew() {
+	defer close(s.done)
+	ticker := time.NewTicker(semaphoreLeaseSeconds * time.Second / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		err := s.update(func(lease *coordinationv1.Lease) {
+			now := v1.NewMicroTime(time.Now())
+			lease.Spec.RenewTime = &now
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "renew semaphore lease %s error: %v\n", s.name, err)
+		}
+	}
+}
+
+// Release frees the slot, a nil slot is a no-op.
+func (s *SemaphoreSlot) Release() {
+	if s == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	err := s.update(func(lease *coordinationv1.Lease) {
+		empty := ""
+		lease.Spec.HolderIdentity = &empty
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "release semaphore lease %s error: %v\n", s.name, err)
+	}
+}
+
+// update changes the lease while this slot still holds it.
+func (s *SemaphoreSlot) update(fn func(*coordinationv1.Lease)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	return RetryAPI(ctx, func() error {
+		leases := s.clientset.CoordinationV1().Leases(s.namespace)
+		lease, err := leases.Get(ctx, s.name, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != s.holder {
+			return fmt.Errorf("the slot expired and was taken over")
+		}
+		fn(lease)
+		_, err = leases.Update(ctx, lease, v1.UpdateOptions{})
+		return err
+	})
+}
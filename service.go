@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	service          = flag.String("service", "", "name[:port] of a service in -ns, pick the pod from its endpoints, only endpoints serving port when given")
+	serviceReadyOnly = flag.Bool("service-ready-only", false, "with -service, only pods that are ready endpoints")
+	servingOnly      = flag.Bool("serving-only", false, "with -service, only pods receiving its traffic, i.e. ready endpoints, e.g. for maintenance during rollouts")
+	nonServingOnly   = flag.Bool("non-serving-only", false, "with -service, only pods matched by -l that receive none of its traffic, e.g. drained replicas")
+)
+
+func validateService(cfgErr *ConfigError) {
+	if *service == "" {
+		for _, flagName := range []string{"service-ready-only", "serving-only", "non-serving-only"} {
+			if flag.Lookup(flagName).Value.String() == "true" {
+				cfgErr.Add(flagName, "requires -service")
+			}
+		}
+		return
+	}
+	if *nonServingOnly {
+		if *servingOnly || *serviceReadyOnly {
+			cfgErr.Add("non-serving-only", "conflicts with -serving-only and -service-ready-only")
+		}
+		if *labels == "" {
+			cfgErr.Add("non-serving-only", "requires -l, the pods not serving are picked among those")
+		}
+	}
+	if name, _ := splitServiceRef(*service); name == "" {
+		cfgErr.Add("service", "must be <name> or <name>:<port>")
+	}
+}
+
+// splitServiceRef splits name:port, the port is a number or a port name.
+func splitServiceRef(ref string) (string, string) {
+	if i := strings.LastIndex(ref, ":"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// ServicePods maps the pods backing the -service endpoints to whether they are ready, taken
+// from its EndpointSlices or, on clusters without discovery.k8s.io/v1, its Endpoints.
+func ServicePods(ctx context.Context, clientset *kubernetes.Clientset, namespace string) (map[string]bool, error) {
+	name, port := splitServiceRef(*service)
+	if !serverFeatures.EndpointSlices {
+		return endpointsPods(ctx, clientset, namespace, name, port)
+	}
+	var slices *discoveryv1.EndpointSliceList
+	err := RetryAPI(ctx, func() (err error) {
+		ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+		defer cancel()
+		slices, err = clientset.DiscoveryV1().EndpointSlices(namespace).List(ctx, v1.ListOptions{
+			LabelSelector: discoveryv1.LabelServiceName + "=" + name,
+		})
+		return err
+	})
+	if errors.IsNotFound(err) {
+		return endpointsPods(ctx, clientset, namespace, name, port)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list endpointslices of service %s error: %v", name, err)
+	}
+	pods := map[string]bool{}
+	for _, slice := range slices.Items {
+		if !sliceServesPort(slice.Ports, port) {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.TargetRef == nil || endpoint.TargetRef.Kind != "Pod" {
+				continue
+			}
+			// a nil ready condition means ready, a pod may be ready in one slice only.
+			ready := endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready
+			pods[endpoint.TargetRef.Name] = pods[endpoint.TargetRef.Name] || ready
+		}
+	}
+	return pods, nil
+}
+
+func endpointsPods(ctx context.Context, clientset *kubernetes.Clientset, namespace string, name string, port string) (map[string]bool, error) {
+	var endpoints *corev1.Endpoints
+	err := RetryAPI(ctx, func() (err error) {
+		ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+		defer cancel()
+		endpoints, err = clientset.CoreV1().Endpoints(namespace).Get(ctx, name, v1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get endpoints of service %s error: %v", name, err)
+	}
+	pods := map[string]bool{}
+	add := func(addresses []corev1.EndpointAddress, ready bool) {
+		for _, address := range addresses {
+			if address.TargetRef != nil && address.TargetRef.Kind == "Pod" {
+				pods[address.TargetRef.Name] = pods[address.TargetRef.Name] || ready
+			}
+		}
+	}
+	for _, subset := range endpoints.Subsets {
+		if !subsetServesPort(subset.Ports, port) {
+			continue
+		}
+		add(subset.Addresses, true)
+		add(subset.NotReadyAddresses, false)
+	}
+	return pods, nil
+}
+
+func sliceServesPort(ports []discoveryv1.EndpointPort, port string) bool {
+	if port == "" {
+		return true
+	}
+	for _, p := range ports {
+		if (p.Name != nil && *p.Name == port) || (p.Port != nil && strconv.Itoa(int(*p.Port)) == port) {
+			return true
+		}
+	}
+	return false
+}
+
+func subsetServesPort(ports []corev1.EndpointPort, port string) bool {
+	if port == "" {
+		return true
+	}
+	for _, p := range ports {
+		if p.Name == port || strconv.Itoa(int(p.Port)) == port {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterService keeps the pods backing the -service endpoints, only the ready ones with
+// -service-ready-only or -serving-only, and with -non-serving-only the pods that are no ready
+// endpoint.
+func FilterService(ctx context.Context, clientset *kubernetes.Clientset, namespace string, pods []corev1.Pod) ([]corev1.Pod, error) {
+	members, err := ServicePods(ctx, clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []corev1.Pod
+	for _, pod := range pods {
+		ready, ok := members[pod.Name]
+		if *nonServingOnly {
+			ok = !ready
+		} else if *serviceReadyOnly || *servingOnly {
+			ok = ready
+		}
+		if ok {
+			filtered = append(filtered, pod)
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+const (
+	OSLinux   = "linux"
+	OSWindows = "windows"
+)
+
+var (
+	targetOS = flag.String("os", OSLinux, "os of the target container, linux or windows")
+	shell    = flag.String("shell", "", "run the command as a script through this shell: sh, bash, cmd or powershell, empty runs it directly")
+	workdir  = flag.String("workdir", "", "directory the command runs in, exec has none of its own, so the command is started through sh or the -shell script changes into it first")
+
+	nsenterPID        = flag.Int("nsenter-pid", 0, "run the command with nsenter in the namespaces of this process, e.g. from a tooling sidecar of a pod with shareProcessNamespace, 0 disables")
+	nsenterNamespaces = flag.String("nsenter-namespaces", "mount,uts,ipc,net,pid", "comma separated namespaces entered with -nsenter-pid: mount, uts, ipc, net, pid, cgroup, user")
+)
+
+var nsenterFlags = map[string]string{
+	"mount":  "--mount",
+	"uts":    "--uts",
+	"ipc":    "--ipc",
+	"net":    "--net",
+	"pid":    "--pid",
+	"cgroup": "--cgroup",
+	"user":   "--user",
+}
+
+// ShellArgs returns the argv running script through the named shell.
+func ShellArgs(shellName string, script string) ([]string, error) {
+	switch shellName {
+	case "sh", "bash":
+		return []string{shellName, "-c", script}, nil
+	case "cmd":
+		return []string{"cmd", "/C", script}, nil
+	case "powershell":
+		return []string{"powershell", "-NoProfile", "-NonInteractive", "-Command", script}, nil
+	}
+	return nil, fmt.Errorf("unknown shell %q", shellName)
+}
+
+// DefaultShell is the shell helper commands run through on the target os.
+func DefaultShell() string {
+	if *targetOS == OSWindows {
+		return "cmd"
+	}
+	return "sh"
+}
+
+// WrapCommand applies -shell, -workdir and then -nsenter-pid to cmd.
+func WrapCommand(cmd []string) []string {
+	return wrapNsenter(wrapWorkdir(wrapShell(cmd)))
+}
+
+// wrapShell applies -shell to cmd. A single argument is the script, more arguments are
+// quoted for the shell each, so they reach the command as given.
+func wrapShell(cmd []string) []string {
+	if *shell == "" {
+		return cmd
+	}
+	script := strings.Join(cmd, " ")
+	if len(cmd) > 1 {
+		words := make([]string, len(cmd))
+		for i, arg := range cmd {
+			words[i] = quoteShellArg(*shell, arg)
+		}
+		script = strings.Join(words, " ")
+		if *shell == "powershell" {
+			// a quoted first word is a string to powershell, & calls it.
+			script = "& " + script
+		}
+	}
+	if *workdir != "" {
+		script = workdirScript(*shell, *workdir) + script
+	}
+	wrapped, err := ShellArgs(*shell, script)
+	if err != nil {
+		return cmd
+	}
+	return wrapped
+}
+
+// quoteShellArg quotes arg for a script of shellName. cmd has no escape for every character,
+// there only arguments with spaces, special characters or empty ones are put in quotes.
+func quoteShellArg(shellName string, arg string) string {
+	switch shellName {
+	case "cmd":
+		if arg != "" && !strings.ContainsAny(arg, " \t&|<>^\"%()") {
+			return arg
+		}
+		return `"` + strings.ReplaceAll(arg, `"`, `""`) + `"`
+	case "powershell":
+		return "'" + strings.ReplaceAll(arg, "'", "''") + "'"
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// workdirScript is the start of a -shell script changing into dir, the script does not run
+// when that fails.
+func workdirScript(shellName string, dir string) string {
+	switch shellName {
+	case "cmd":
+		return fmt.Sprintf(`cd /d "%s" && `, strings.ReplaceAll(dir, `"`, `""`))
+	case "powershell":
+		return fmt.Sprintf("Set-Location -ErrorAction Stop -LiteralPath '%s'; ", strings.ReplaceAll(dir, "'", "''"))
+	}
+	return fmt.Sprintf("cd %s && ", shellQuote(dir))
+}
+
+// wrapWorkdir starts cmd in -workdir through sh when there is no -shell script doing so,
+// the arguments are passed on unchanged.
+func wrapWorkdir(cmd []string) []string {
+	if *workdir == "" || *shell != "" {
+		return cmd
+	}
+	return append([]string{"sh", "-c", `cd "$1" && shift && exec "$@"`, "sh", *workdir}, cmd...)
+}
+
+// wrapNsenter runs cmd through nsenter into the -nsenter-namespaces of -nsenter-pid.
+func wrapNsenter(cmd []string) []string {
+	if *nsenterPID == 0 {
+		return cmd
+	}
+	wrapped := []string{"nsenter", "--target", fmt.Sprint(*nsenterPID)}
+	for _, ns := range strings.Split(*nsenterNamespaces, ",") {
+		wrapped = append(wrapped, nsenterFlags[strings.TrimSpace(ns)])
+	}
+	return append(append(wrapped, "--"), cmd...)
+}
+
+func validateWorkdir(cfgErr *ConfigError) {
+	if *workdir != "" && *shell == "" && *targetOS == OSWindows {
+		cfgErr.Add("workdir", "requires -shell cmd or powershell with -os windows")
+	}
+}
+
+func validateNsenter(cfgErr *ConfigError) {
+	if *nsenterPID == 0 {
+		return
+	}
+	if *nsenterPID < 0 {
+		cfgErr.Add("nsenter-pid", "must not be negative")
+	}
+	if *targetOS != OSLinux {
+		cfgErr.Add("nsenter-pid", "requires -os linux")
+	}
+	for _, ns := range strings.Split(*nsenterNamespaces, ",") {
+		if _, ok := nsenterFlags[strings.TrimSpace(ns)]; !ok {
+			cfgErr.Add("nsenter-namespaces", "unknown namespace %q, want mount, uts, ipc, net, pid, cgroup or user", strings.TrimSpace(ns))
+		}
+	}
+}
+
+// NormalizeOutput turns windows line endings into \n for the json result.
+func NormalizeOutput(s string) string {
+	if *targetOS != OSWindows {
+		return s
+	}
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	lokiURL    = flag.String("loki-url", "", "loki base url, e.g. http://loki:3100, every run record is pushed to /loki/api/v1/push")
+	lokiTenant = flag.String("loki-tenant", "", "X-Scope-OrgID of the loki push, for multi-tenant loki")
+	esURL      = flag.String("es-url", "", "elasticsearch base url, e.g. https://es:9200, every run record is indexed through the bulk api")
+	esIndex    = flag.String("es-index", "k8s-cronjob-{{date}}", "elasticsearch index of the run records, {{date}} is replaced by the UTC date as 2006.01.02")
+	esAPIKey   = flag.String("es-api-key", "", "elasticsearch api key, better set through K8S_CRONJOB_ES_API_KEY, basic auth goes in -es-url")
+	sinkOutput = flag.Bool("sink-output", false, "include stdout and stderr in the records sent to -loki-url and -es-url")
+)
+
+var lokiLabels stringsFlag
+
+func init() {
+	flag.Var(&lokiLabels, "loki-label", "key=value label of the loki stream besides job, namespace and status, repeatable")
+}
+
+func validateSinks(cfgErr *ConfigError) {
+	urls := map[string]string{
+		"loki-url": *lokiURL,
+		"es-url":   *esURL,
+	}
+	for _, name := range []string{"loki-url", "es-url"} {
+		if urls[name] == "" {
+			continue
+		}
+		if u, err := url.Parse(urls[name]); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			cfgErr.Add(name, "must be an http(s) url")
+		}
+	}
+	for _, label := range lokiLabels {
+		if strings.Index(label, "=") <= 0 {
+			cfgErr.Add("loki-label", "malformed label %q, want key=value", label)
+		}
+	}
+	if len(lokiLabels) > 0 && *lokiURL == "" {
+		cfgErr.Add("loki-label", "requires -loki-url")
+	}
+	if *lokiTenant != "" && *lokiURL == "" {
+		cfgErr.Add("loki-tenant", "requires -loki-url")
+	}
+	if *esIndex == "" || strings.ToLower(*esIndex) != *esIndex {
+		cfgErr.Add("es-index", "must be a lowercase index name")
+	}
+	if *esAPIKey != "" && *esURL == "" {
+		cfgErr.Add("es-api-key", "requires -es-url")
+	}
+}
+
+// RunRecord is the structured record of a run sent to the log sinks, the reply with the
+// namespace and the command, without the output unless -sink-output is set.
+func RunRecord(target *Target, start time.Time, resp *Response) map[string]interface{} {
+	record := BuildReply(resp)
+	if !*sinkOutput {
+		delete(record, "stdout")
+		delete(record, "stderr")
+	}
+	command := RedactArgs(target.Command)
+	record["namespace"] = target.Namespace
+	record["command"] = command
+	record["status"] = resp.Status()
+	record["@timestamp"] = start.UTC().Format(time.RFC3339Nano)
+	if code, ok := ExitCode(resp.Error); ok {
+		record["exitCode"] = code
+	}
+	return record
+}
+
+// SendToSinks pushes the run record to -loki-url and -es-url when they are set.
+func SendToSinks(target *Target, start time.Time, resp *Response) error {
+	if *lokiURL == "" && *esURL == "" {
+		return nil
+	}
+	record := RunRecord(target, start, resp)
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	if *lokiURL != "" {
+		if err := pushLoki(target, start, resp, line); err != nil {
+			errs = append(errs, fmt.Sprintf("loki push error: %v", err))
+		}
+	}
+	if *esURL != "" {
+		if err := indexElasticsearch(start, line); err != nil {
+			errs = append(errs, fmt.Sprintf("elasticsearch bulk error: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func pushLoki(target *Target, start time.Time, resp *Response, line []byte) error {
+	labels := map[string]string{
+		"job":       "k8s-cronjob",
+		"namespace": target.Namespace,
+		"status":    resp.Status(),
+	}
+	for _, label := range lokiLabels {
+		if i := strings.Index(label, "="); i > 0 {
+			labels[label[:i]] = label[i+1:]
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"streams": []map[string]interface{}{{
+			"stream": labels,
+			"values": [][]string{{strconv.FormatInt(start.UnixNano(), 10), string(line)}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	if *lokiTenant != "" {
+		header.Set("X-Scope-OrgID", *lokiTenant)
+	}
+	_, err = postSink(strings.TrimRight(*lokiURL, "/")+"/loki/api/v1/push", header, body)
+	return err
+}
+
+func indexElasticsearch(start time.Time, line []byte) error {
+	index := strings.ReplaceAll(*esIndex, "{{date}}", start.UTC().Format("2006.01.02"))
+	action, err := json.Marshal(map[string]interface{}{
+		"index": map[string]string{
+			"_index": index,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	body.Write(action)
+	body.WriteByte('\n')
+	body.Write(line)
+	body.WriteByte('\n')
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-ndjson")
+	if *esAPIKey != "" {
+		header.Set("Authorization", "ApiKey "+*esAPIKey)
+	}
+	b, err := postSink(strings.TrimRight(*esURL, "/")+"/_bulk", header, body.Bytes())
+	if err != nil {
+		return err
+	}
+	// the bulk api answers 200 also when items failed.
+	result := struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}{}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return fmt.Errorf("decode response error: %v", err)
+	}
+	for _, item := range result.Items {
+		for _, status := range item {
+			if status.Error != nil {
+				return fmt.Errorf("%s: %s", status.Error.Type, status.Error.Reason)
+			}
+		}
+	}
+	if result.Errors {
+		return fmt.Errorf("the record was not indexed")
+	}
+	return nil
+}
+
+// postSink posts body and returns the response body, any non 2xx status is an error.
+func postSink(endpoint string, header http.Header, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s responded %s: %s", endpoint, resp.Status, strings.TrimSpace(cutUTF8(string(b), 512)))
+	}
+	return b, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"k8s.io/client-go/rest"
+)
+
+// sshKeepAlive is how often the tunnel is pinged, so idle firewalls do not drop it.
+const sshKeepAlive = 30 * time.Second
+
+var (
+	sshTunnel     = flag.String("ssh-tunnel", "", "user@bastion[:port] the api requests and exec streams are tunneled through, for clusters without a public endpoint")
+	sshKey        = flag.String("ssh-key", "", "private key of -ssh-tunnel, default the ssh-agent keys and ~/.ssh/id_ed25519, id_ecdsa and id_rsa")
+	sshKnownHosts = flag.String("ssh-known-hosts", "", "known_hosts file verifying the bastion host key, default ~/.ssh/known_hosts")
+)
+
+// parseSSHTunnel splits user@host[:port], the port defaults to 22.
+func parseSSHTunnel(s string) (string, string, error) {
+	i := strings.LastIndex(s, "@")
+	if i <= 0 || i == len(s)-1 {
+		return "", "", fmt.Errorf("malformed %q, want user@host[:port]", s)
+	}
+	user, addr := s[:i], s[i+1:]
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "22")
+	}
+	return user, addr, nil
+}
+
+// ApplySSHTunnel routes api requests and exec streams of config through an ssh connection
+// to -ssh-tunnel, the api server is dialed from the bastion. Like a socks5 proxy the tunnel
+// is fronted by a local CONNECT bridge, so the api server certificate is verified as usual.
+func ApplySSHTunnel(config *rest.Config) error {
+	if *sshTunnel == "" {
+		return nil
+	}
+	cfgErr := &ConfigError{}
+	user, addr, err := parseSSHTunnel(*sshTunnel)
+	if err != nil {
+		cfgErr.Add("ssh-tunnel", "%v", err)
+	}
+	if *proxyURL != "" {
+		cfgErr.AddConflict("ssh-tunnel", []string{"proxy-url"}, "the api server is reached through the bastion")
+	}
+	if err := cfgErr.ErrOrNil(); err != nil {
+		return err
+	}
+	auth, err := sshAuthMethods()
+	if err != nil {
+		return fmt.Errorf("ssh tunnel error: %v", err)
+	}
+	knownHostsFile := *sshKnownHosts
+	if knownHostsFile == "" {
+		home, _ := os.UserHomeDir()
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return fmt.Errorf("ssh tunnel error: load known hosts: %v, set -ssh-known-hosts", err)
+	}
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("ssh tunnel to %s error: %v", addr, err)
+	}
+	go sshKeepAlives(client)
+	u, err := startConnectBridge(client.Dial)
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("start ssh tunnel bridge error: %v", err)
+	}
+	config.Proxy = http.ProxyURL(u)
+	return nil
+}
+
+// sshAuthMethods authenticates with -ssh-key, or with the ssh-agent and the default keys.
+func sshAuthMethods() ([]ssh.AuthMethod, error) {
+	if *sshKey != "" {
+		signer, err := loadSSHKey(*sshKey)
+		if err != nil {
+			return nil, err
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+	var methods []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	home, _ := os.UserHomeDir()
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		path := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		// an encrypted default key is left to the agent.
+		if signer, err := loadSSHKey(path); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no ssh key, set -ssh-key or SSH_AUTH_SOCK")
+	}
+	return methods, nil
+}
+
+func loadSSHKey(path string) (ssh.Signer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(b)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("%s is encrypted, add it to the ssh-agent instead", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s error: %v", path, err)
+	}
+	return signer, nil
+}
+
+// sshKeepAlives pings the bastion until the connection is gone.
+func sshKeepAlives(client *ssh.Client) {
+	ticker := time.NewTicker(sshKeepAlive)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	statefulSet    = flag.String("statefulset", "", "name of a statefulset in -ns, with -all run the command on its replicas")
+	ordered        = flag.Bool("ordered", false, "with -statefulset, run on one replica after the other in ordinal order, each waiting for the previous to succeed and for its pod to be ready")
+	orderedReverse = flag.Bool("ordered-reverse", false, "with -ordered, start at the highest ordinal like a rolling update")
+)
+
+func validateStatefulSet(cfgErr *ConfigError) {
+	if *statefulSet != "" && !*allPods {
+		cfgErr.Add("statefulset", "requires -all, a single replica is picked with -pn <name>-<ordinal>")
+	}
+	if *orderedReverse && !*ordered {
+		cfgErr.Add("ordered-reverse", "requires -ordered")
+	}
+	if !*ordered {
+		return
+	}
+	if *statefulSet == "" {
+		cfgErr.Add("ordered", "requires -statefulset")
+	}
+	if filters := setFlags(targetFilters); len(filters) > 0 {
+		cfgErr.AddConflict("ordered", filters, "every replica is run")
+	}
+	if *parallel > 1 {
+		cfgErr.Add("ordered", "conflicts with -parallel, one replica runs at a time")
+	}
+	if *canaryFirst {
+		cfgErr.Add("ordered", "conflicts with -canary-first, the first ordinal is the canary")
+	}
+	if *minSuccess != "100%" {
+		cfgErr.Add("ordered", "conflicts with -min-success, a failed replica stops the run")
+	}
+}
+
+// RunStatefulSet executes the target command on the replicas of -statefulset, with -ordered
+// one at a time by ordinal and only while they succeed, otherwise like -all.
+func RunStatefulSet(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, target *Target) *BatchReport {
+	start := time.Now()
+	report := &BatchReport{}
+	var replicas int32 = 1
+	var selector string
+	err := RetryAPI(ctx, func() error {
+		s, err := clientset.AppsV1().StatefulSets(target.Namespace).Get(ctx, *statefulSet, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if s.Spec.Replicas != nil {
+			replicas = *s.Spec.Replicas
+		}
+		selector = v1.FormatLabelSelector(s.Spec.Selector)
+		return nil
+	})
+	if err != nil {
+		report.err = fmt.Errorf("get statefulset %s error: %v", *statefulSet, err)
+		report.Failed = 1
+		report.Pods = append(report.Pods, &PodResult{
+			Status: PodStatusFailed,
+			Error:  report.err.Error(),
+		})
+		report.Duration = time.Since(start).String()
+		report.Table = report.FormatTable()
+		return report
+	}
+	target.Name = "statefulset/" + *statefulSet
+	target.Labels = joinLabelSelectors(selector, target.Labels)
+	if !*ordered {
+		return RunFanOut(ctx, clientset, config, target)
+	}
+	for n := int32(0); n < replicas; n++ {
+		ordinal := n
+		if *orderedReverse {
+			ordinal = replicas - 1 - n
+		}
+		podName := fmt.Sprintf("%s-%d", *statefulSet, ordinal)
+		if report.aborted != "" {
+			report.notRun++
+			report.Pods = append(report.Pods, &PodResult{
+				Pod:    podName,
+				Status: PodStatusSkipped,
+				Error:  "not run, " + report.aborted,
+			})
+			continue
+		}
+		if n > 0 && *stagger > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(*stagger):
+			}
+		}
+		if ctx.Err() != nil {
+			report.aborted = fmt.Sprintf("run cancelled (%v)", ctx.Err())
+			report.notRun++
+			report.Pods = append(report.Pods, &PodResult{
+				Pod:    podName,
+				Status: PodStatusCancelled,
+				Error:  "not run, cancelled",
+			})
+			continue
+		}
+		var result *PodResult
+		if err := waitReplicaReady(ctx, clientset, target.Namespace, podName); err != nil {
+			result = &PodResult{
+				Pod:    podName,
+				Status: PodStatusFailed,
+				Error:  err.Error(),
+			}
+		} else {
+			result = runInPod(ctx, clientset, config, target, podName)
+		}
+		report.Pods = append(report.Pods, result)
+		if result.Status != PodStatusSucceeded {
+			report.aborted = fmt.Sprintf("replica %s %s", podName, result.Status)
+		}
+	}
+	if report.notRun == 0 {
+		// the last replica failed, nothing was left out.
+		report.aborted = ""
+	}
+	for _, result := range report.Pods {
+		switch result.Status {
+		case PodStatusSucceeded:
+			report.Succeeded++
+		case PodStatusFailed:
+			report.Failed++
+		case PodStatusSkippedUnhealthy:
+			report.Skipped++
+			report.unhealthy++
+		default:
+			report.Skipped++
+		}
+	}
+	report.Duration = time.Since(start).String()
+	report.Table = report.FormatTable()
+	return report
+}
+
+// waitReplicaReady waits up to -wp for the pod to exist, be ready and match -wait-for and
+// -where, as the statefulset controller waits before it updates the next replica.
+func waitReplicaReady(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string) error {
+	deadline := time.Now().Add(*waitRunningPodTimeout)
+	for {
+		var pod *corev1.Pod
+		err := RetryAPI(ctx, func() (err error) {
+			ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+			defer cancel()
+			pod, err = clientset.CoreV1().Pods(namespace).Get(ctx, podName, v1.GetOptions{})
+			return err
+		})
+		var reason string
+		switch {
+		case errors.IsNotFound(err):
+			reason = "does not exist"
+		case err != nil:
+			return fmt.Errorf("get pod %s error: %v", podName, err)
+		case pod.DeletionTimestamp != nil:
+			reason = "is terminating"
+		case !podReady(pod) || !IsEligiblePod(pod):
+			reason = fmt.Sprintf("is %s and not ready", pod.Status.Phase)
+		default:
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("replica %s %s", podName, reason)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("replica %s %s: %v", podName, reason, ctx.Err())
+		case <-time.After(*pollInterval):
+		}
+	}
+}
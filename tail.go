@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+var endWebhookOutputKB = flag.Int("ew-output-kb", 0, "with -ew, post the first and the last this many KB of the combined stdout and stderr of a failed run as output.head and output.tail instead of stdout and stderr, 0 posts the whole output")
+
+// CombinedOutput is the start and the end of stdout and stderr together, in the order the
+// command wrote them.
+type CombinedOutput struct {
+	Head      string `json:"head"`
+	Tail      string `json:"tail,omitempty"`
+	Bytes     int64  `json:"bytes"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+func validateEndWebhookOutput(cfgErr *ConfigError) {
+	if *endWebhookOutputKB < 0 {
+		cfgErr.Add("ew-output-kb", "must not be negative")
+	} else if *endWebhookOutputKB > 0 && *endWebhook == "" {
+		cfgErr.Add("ew-output-kb", "requires -ew")
+	}
+}
+
+// outputTail keeps the first limit bytes written to it and the last limit bytes after those in
+// a ring buffer, so memory stays bounded however much the command writes.
+type outputTail struct {
+	mu    sync.Mutex
+	limit int
+	head  []byte
+	ring  []byte
+	next  int
+	total int64
+}
+
+func newOutputTail(limit int) *outputTail {
+	return &outputTail{
+		limit: limit,
+	}
+}
+
+func (o *outputTail) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := len(p)
+	o.total += int64(n)
+	if room := o.limit - len(o.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		o.head = append(o.head, p[:room]...)
+		p = p[room:]
+	}
+	if len(p) > o.limit {
+		p = p[len(p)-o.limit:]
+	}
+	for len(p) > 0 {
+		if len(o.ring) < o.limit {
+			k := o.limit - len(o.ring)
+			if k > len(p) {
+				k = len(p)
+			}
+			o.ring = append(o.ring, p[:k]...)
+			p = p[k:]
+			continue
+		}
+		k := copy(o.ring[o.next:], p)
+		o.next = (o.next + k) % o.limit
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// Reset drops what was written, for a stream that is started over.
+func (o *outputTail) Reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.head = nil
+	o.ring = nil
+	o.next = 0
+	o.total = 0
+}
+
+// Output returns the head and the tail without utf-8 sequences split at the cuts. The tail is
+// empty while everything fits the head, and continues the head when nothing was dropped.
+func (o *outputTail) Output() *CombinedOutput {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	tail := append(append([]byte{}, o.ring[o.next:]...), o.ring[:o.next]...)
+	out := &CombinedOutput{
+		Head:      NormalizeOutput(string(o.head)),
+		Bytes:     o.total,
+		Truncated: o.total > int64(len(o.head)+len(tail)),
+	}
+	if out.Truncated {
+		out.Head = NormalizeOutput(string(o.head[:len(o.head)-partialRuneSuffix(o.head)]))
+		for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+			tail = tail[1:]
+		}
+	}
+	out.Tail = NormalizeOutput(string(tail))
+	return out
+}
+
+// partialRuneSuffix is the length of an incomplete utf-8 sequence at the end of b.
+func partialRuneSuffix(b []byte) int {
+	for i := 1; i <= utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if utf8.FullRune(b[len(b)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// tailWriter copies every write to the combined output.
+type tailWriter struct {
+	w io.Writer
+	t *outputTail
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.t.Write(p)
+	return t.w.Write(p)
+}
+
+// Reset passes a restart of the stream on to the wrapped writer and starts the combined
+// output over.
+func (t *tailWriter) Reset() error {
+	t.t.Reset()
+	return resetWriter(t.w)
+}
+
+// EndWebhookReply is the reply posted to -ew. With -ew-output-kb it carries no stdout and
+// stderr, a failed run the head and the tail of its output instead.
+func EndWebhookReply(resp *Response) map[string]interface{} {
+	reply := BuildReply(resp)
+	if *endWebhookOutputKB <= 0 {
+		return reply
+	}
+	delete(reply, "stdout")
+	delete(reply, "stderr")
+	if resp.Output != nil && (resp.Error != nil || resp.Cancelled) {
+		reply["output"] = resp.Output
+	}
+	return reply
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// RunTargets prints every pod the target flags match and marks the one a run would pick.
+func RunTargets() {
+	if err := ValidateTargetFlags(); err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	selector, _ := BuildLabelSelector()
+	CompilePodSelection()
+	_, clientset, err := NewClient()
+	if err != nil {
+		SendError(&Response{
+			Error: err,
+		})
+	}
+	pods, err := ListCandidatePods(context.Background(), clientset, *namespace, selector, BuildFieldSelector(*podIP, *nodeName), *podName)
+	if err != nil {
+		SendError(&Response{
+			Error: fmt.Errorf("list pods error: %v", err),
+		})
+	}
+	PrintTargets(pods, PickPod(pods))
+}
+
+func PrintTargets(pods []corev1.Pod, picked *corev1.Pod) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PICK\tNAME\tPHASE\tREADY\tNODE\tAGE")
+	for _, pod := range pods {
+		mark := ""
+		if picked != nil && picked.UID == pod.UID {
+			mark = "*"
+		}
+		age := "<unknown>"
+		if !pod.CreationTimestamp.IsZero() {
+			age = duration.HumanDuration(time.Since(pod.CreationTimestamp.Time))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", mark, pod.Name, pod.Status.Phase, podReadyString(&pod), pod.Spec.NodeName, age)
+	}
+	w.Flush()
+	if len(pods) == 0 {
+		fmt.Println("no pods matched")
+	}
+}
+
+func podReadyString(pod *corev1.Pod) string {
+	ready := 0
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Ready {
+			ready++
+		}
+	}
+	return fmt.Sprintf("%d/%d", ready, len(pod.Spec.Containers))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// tcpConnectWait is how long a connect without -tcp-send waits for the kubelet to report the
+// port refused, it connects to the pod port when the data stream is opened.
+const tcpConnectWait = 2 * time.Second
+
+var (
+	tcpPort    = flag.Int("port", 0, "the pod port -action tcp-check connects to through a port-forward")
+	tcpSend    = flag.String("tcp-send", "", "with -action tcp-check, send this after connecting, Go escapes like \\n are interpreted")
+	tcpExpect  = flag.String("tcp-expect", "", "with -action tcp-check, regular expression the response must match")
+	tcpTimeout = flag.Duration("tcp-timeout", 10*time.Second, "how long -action tcp-check waits for the connection and the response")
+)
+
+func validateTCPCheck(cfgErr *ConfigError) {
+	if *action != ActionTCPCheck {
+		for _, flagName := range []string{"port", "tcp-send", "tcp-expect"} {
+			if v := flag.Lookup(flagName).Value.String(); v != "" && v != "0" {
+				cfgErr.Add(flagName, "requires -action tcp-check")
+			}
+		}
+		return
+	}
+	if *tcpPort < 1 || *tcpPort > 65535 {
+		cfgErr.Add("port", "must be a port between 1 and 65535")
+	}
+	if _, err := tcpPayload(); err != nil {
+		cfgErr.Add("tcp-send", "malformed escapes: %v", err)
+	}
+	if _, err := regexp.Compile(*tcpExpect); err != nil {
+		cfgErr.Add("tcp-expect", "malformed regular expression: %v", err)
+	}
+	if *tcpTimeout <= 0 {
+		cfgErr.Add("tcp-timeout", "must be positive")
+	}
+}
+
+func tcpPayload() (string, error) {
+	if *tcpSend == "" {
+		return "", nil
+	}
+	return strconv.Unquote(`"` + strings.ReplaceAll(*tcpSend, `"`, `\"`) + `"`)
+}
+
+// TCPCheck connects to -port of the pod through a port-forward, sends -tcp-send and reads the
+// response until it matches -tcp-expect. The response is the stdout.
+func TCPCheck(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, namespace string, podName string) (*ExecOutput, error) {
+	out := &ExecOutput{}
+	ctx, cancel := context.WithTimeout(ctx, *tcpTimeout)
+	defer cancel()
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return out, fmt.Errorf("port-forward error: %v", err)
+	}
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(podName).
+		SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
+	conn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	if err != nil {
+		return out, fmt.Errorf("port-forward error: %v", err)
+	}
+	defer conn.Close()
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, strconv.Itoa(*tcpPort))
+	headers.Set(corev1.PortForwardRequestIDHeader, "0")
+	errorStream, err := conn.CreateStream(headers)
+	if err != nil {
+		return out, fmt.Errorf("port-forward error: %v", err)
+	}
+	// only read from.
+	errorStream.Close()
+	remoteErr := make(chan string, 1)
+	go func() {
+		b, _ := ioutil.ReadAll(errorStream)
+		remoteErr <- strings.TrimSpace(string(b))
+	}()
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := conn.CreateStream(headers)
+	if err != nil {
+		return out, fmt.Errorf("port-forward error: %v", err)
+	}
+	defer dataStream.Close()
+	payload, _ := tcpPayload()
+	if payload != "" {
+		if _, err := dataStream.Write([]byte(payload)); err != nil {
+			return out, fmt.Errorf("send to port %d error: %v", *tcpPort, err)
+		}
+	}
+	var expect *regexp.Regexp
+	if *tcpExpect != "" {
+		expect = regexp.MustCompile(*tcpExpect)
+	}
+	received := make(chan string)
+	readDone := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := dataStream.Read(buf)
+			if n > 0 {
+				select {
+				case received <- string(buf[:n]):
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				readDone <- err
+				return
+			}
+		}
+	}()
+	var response strings.Builder
+	settle := time.NewTimer(tcpConnectWait)
+	defer settle.Stop()
+	for {
+		select {
+		case msg := <-remoteErr:
+			if msg != "" {
+				return out, fmt.Errorf("connect to port %d error: %s", *tcpPort, msg)
+			}
+			// the kubelet closes the error stream empty when the connection ends.
+			remoteErr = nil
+		case data := <-received:
+			response.WriteString(data)
+			out.Stdout = NormalizeOutput(strings.TrimSpace(response.String()))
+			if expect != nil && expect.MatchString(response.String()) {
+				return out, nil
+			}
+		case <-readDone:
+			readDone = nil
+			if expect != nil {
+				// a refused connect closes the data stream too, prefer its reason.
+				select {
+				case msg := <-remoteErr:
+					if msg != "" {
+						return out, fmt.Errorf("connect to port %d error: %s", *tcpPort, msg)
+					}
+				case <-time.After(time.Second):
+				}
+				return out, fmt.Errorf("port %d closed the connection, the response does not match -tcp-expect", *tcpPort)
+			}
+		case <-settle.C:
+			if expect == nil {
+				if out.Stdout == "" {
+					out.Stdout = fmt.Sprintf("connected to port %d", *tcpPort)
+				}
+				return out, nil
+			}
+		case <-ctx.Done():
+			if expect != nil {
+				return out, fmt.Errorf("the response of port %d does not match -tcp-expect after %s", *tcpPort, *tcpTimeout)
+			}
+			return out, fmt.Errorf("connect to port %d timeout", *tcpPort)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	// the alpine image has no zoneinfo.
+	_ "time/tzdata"
+)
+
+const (
+	OutputJSON = "json"
+	OutputText = "text"
+)
+
+var (
+	outputFormat    = flag.String("output", "", "json or text, default text when stdout is a terminal and json otherwise")
+	displayTimezone = flag.String("display-timezone", "UTC", "timezone times are shown in by -output text and in messages, e.g. Europe/Berlin or Local, json fields stay RFC3339 in UTC")
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorDim    = "\x1b[2m"
+)
+
+func validateDisplayTimezone(cfgErr *ConfigError) {
+	if _, err := time.LoadLocation(*displayTimezone); err != nil {
+		cfgErr.Add("display-timezone", "%v", err)
+	}
+}
+
+// DisplayTime formats t for people, in -display-timezone.
+func DisplayTime(t time.Time) string {
+	if loc, err := time.LoadLocation(*displayTimezone); err == nil {
+		t = t.In(loc)
+	}
+	return t.Format("2006-01-02 15:04:05 MST")
+}
+
+// stdoutIsTerminal reports whether stdout is a character device.
+func stdoutIsTerminal() bool {
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// TextOutput reports whether the response is printed as text rather than json.
+func TextOutput() bool {
+	switch *outputFormat {
+	case OutputText:
+		return true
+	case "":
+		return stdoutIsTerminal()
+	}
+	return false
+}
+
+// textPrinter colors only when writing to a terminal and NO_COLOR is unset.
+type textPrinter struct {
+	w     io.Writer
+	color bool
+}
+
+func (p *textPrinter) paint(color string, s string) string {
+	if !p.color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+func (p *textPrinter) field(name string, value string) {
+	fmt.Fprintf(p.w, "%s %s\n", p.paint(colorDim, fmt.Sprintf("%-10s", name+":")), value)
+}
+
+func (p *textPrinter) section(name string, body string) {
+	if body == "" {
+		return
+	}
+	fmt.Fprintln(p.w, p.paint(colorBold, "--- "+name+" ---"))
+	fmt.Fprintln(p.w, strings.TrimRight(body, "\n"))
+}
+
+// PrintText writes a human readable summary of the response.
+func PrintText(w io.Writer, resp *Response) {
+	_, noColor := os.LookupEnv("NO_COLOR")
+	p := &textPrinter{
+		w:     w,
+		color: !noColor && stdoutIsTerminal(),
+	}
+	p.print(resp)
+}
+
+func (p *textPrinter) print(resp *Response) {
+	switch resp.Status() {
+	case PodStatusSucceeded:
+		p.field("status", p.paint(colorGreen, "ok"))
+	case PodStatusCancelled, PodStatusTimedOutPartial, PodStatusSkippedUnhealthy, PodStatusSkippedCooldown, PodStatusPaused, PodStatusTargetChurned:
+		p.field("status", p.paint(colorYellow, resp.Status()))
+	default:
+		p.field("status", p.paint(colorRed, "failed"))
+	}
+	if resp.Target != "" {
+		p.field("target", resp.Target)
+	}
+	if resp.SkipReason != "" {
+		p.field("skipped", resp.SkipReason)
+	}
+	if resp.Pod != "" {
+		p.field("pod", resp.Pod)
+	}
+	if !resp.Started.IsZero() {
+		p.field("started", DisplayTime(resp.Started))
+	}
+	if resp.Duration != "" {
+		duration := resp.Duration
+		if resp.Slow {
+			duration += p.paint(colorYellow, fmt.Sprintf(" (slow, median %s)", resp.MedianDuration))
+		}
+		p.field("duration", duration)
+	}
+	if resp.StdoutFile != "" {
+		p.field("stdout", resp.StdoutFile)
+	}
+	if resp.StderrFile != "" {
+		p.field("stderr", resp.StderrFile)
+	}
+	if resp.Error != nil {
+		p.field("error", p.paint(colorRed, resp.Error.Error()))
+		var cfgErr *ConfigError
+		if errors.As(resp.Error, &cfgErr) {
+			for _, problem := range cfgErr.Problems {
+				if problem.Flag != "" {
+					fmt.Fprintf(p.w, "  -%s: %s\n", problem.Flag, problem.Message)
+				} else {
+					fmt.Fprintf(p.w, "  %s\n", problem.Message)
+				}
+			}
+		}
+		if hint := ErrorHint(resp); hint != "" {
+			p.field("hint", hint)
+		}
+	}
+	if resp.Verify != nil {
+		status := p.paint(colorGreen, "ok")
+		if resp.Verify.Error != "" {
+			status = p.paint(colorRed, resp.Verify.Error)
+		}
+		p.field("verify", fmt.Sprintf("%s: %s", resp.Verify.Command, status))
+	}
+	for _, c := range resp.PostConditions {
+		status := p.paint(colorGreen, "ok")
+		if c.Error != "" {
+			status = p.paint(colorRed, c.Error)
+		}
+		p.field("condition", fmt.Sprintf("%s: %s", c.Condition, status))
+	}
+	if u := resp.Usage; u != nil {
+		if u.Samples == 0 {
+			p.field("usage", p.paint(colorYellow, u.Error))
+		} else {
+			memory := fmt.Sprintf("memory max %s avg %s", formatBytes(u.MemoryBytes.Max), formatBytes(u.MemoryBytes.Avg))
+			if u.MemoryLimitBytes > 0 {
+				memory += fmt.Sprintf(" of %s", formatBytes(u.MemoryLimitBytes))
+				if u.MemoryBytes.Max*10 >= u.MemoryLimitBytes*9 {
+					memory = p.paint(colorYellow, memory)
+				}
+			}
+			p.field("usage", fmt.Sprintf("cpu max %dm avg %dm, %s, %d samples", u.CPUMillicores.Max, u.CPUMillicores.Avg, memory, u.Samples))
+		}
+	}
+	if resp.Report != nil {
+		p.field("pods", fmt.Sprintf("%d succeeded, %d failed, %d skipped", resp.Report.Succeeded, resp.Report.Failed, resp.Report.Skipped))
+		p.section("report", resp.Report.Table)
+		p.section("diff", resp.Report.Diff)
+	}
+	if resp.Jobs != nil {
+		p.field("jobs", fmt.Sprintf("%d succeeded, %d failed, %d skipped", resp.Jobs.Succeeded, resp.Jobs.Failed, resp.Jobs.Skipped))
+		p.section("report", resp.Jobs.Table)
+	}
+	if resp.Check != nil {
+		p.field("pods", fmt.Sprintf("%d matched, %d eligible, %d ready", resp.Check.Matched, resp.Check.Eligible, resp.Check.Ready))
+		if resp.Check.Leader != "" {
+			p.field("leader", resp.Check.Leader)
+		}
+		for _, failure := range resp.Check.Failures {
+			fmt.Fprintf(p.w, "  %s\n", p.paint(colorRed, failure))
+		}
+	}
+	if resp.Diagnostics != nil {
+		p.field("matched", fmt.Sprintf("%d pods", resp.Diagnostics.Matched))
+		for _, pending := range resp.Diagnostics.Pending {
+			fmt.Fprintf(p.w, "  %s pending: %s %s\n", pending.Pod, pending.Reason, pending.Message)
+		}
+		for _, e := range resp.Diagnostics.Errors {
+			fmt.Fprintf(p.w, "  %s\n", e)
+		}
+	}
+	if len(resp.Steps) > 1 {
+		for i, step := range resp.Steps {
+			fmt.Fprintln(p.w, p.paint(colorBold, fmt.Sprintf("=== step %d ===", i+1)))
+			p.print(step)
+		}
+		return
+	}
+	p.section("stdout", resp.Stdout)
+	p.section("stderr", resp.Stderr)
+	for _, d := range resp.FailureDiagnostics {
+		out := d.Stdout
+		if d.Stderr != "" {
+			out = strings.TrimSpace(out + "\n" + d.Stderr)
+		}
+		if d.Error != "" {
+			out = strings.TrimSpace(out + "\n" + d.Error)
+		}
+		p.section("diagnostic: "+d.Command, out)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var sampleUsage = flag.Duration("sample-usage", 0, "sample the cpu and memory of the target container from metrics-server this often during the command and report min, max and average, metrics-server refreshes every 15s by default, 0 disables")
+
+// UsageStats summarizes the samples of the target container taken during the command.
+// Without -cn every container of the pod is summed.
+type UsageStats struct {
+	Samples       int        `json:"samples"`
+	CPUMillicores UsageRange `json:"cpuMillicores"`
+	MemoryBytes   UsageRange `json:"memoryBytes"`
+	// limits of the container, 0 when there is none.
+	CPULimitMillicores int64  `json:"cpuLimitMillicores,omitempty"`
+	MemoryLimitBytes   int64  `json:"memoryLimitBytes,omitempty"`
+	Error              string `json:"error,omitempty"`
+}
+
+type UsageRange struct {
+	Min int64 `json:"min"`
+	Max int64 `json:"max"`
+	Avg int64 `json:"avg"`
+}
+
+// podMetrics is the part of a metrics.k8s.io/v1beta1 PodMetrics used here.
+type podMetrics struct {
+	Containers []struct {
+		Name  string                                    `json:"name"`
+		Usage map[corev1.ResourceName]resource.Quantity `json:"usage"`
+	} `json:"containers"`
+}
+
+// usageSampler samples the usage of a container until stopped.
+type usageSampler struct {
+	stop chan struct{}
+	done chan struct{}
+
+	mu        sync.Mutex
+	stats     *UsageStats
+	cpuSum    int64
+	memorySum int64
+}
+
+// StartUsageSampler samples the container every -sample-usage, nil when it is not set.
+func StartUsageSampler(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string, containerName string) *usageSampler {
+	if *sampleUsage <= 0 {
+		return nil
+	}
+	s := &usageSampler{
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		stats: &UsageStats{},
+	}
+	s.stats.CPULimitMillicores, s.stats.MemoryLimitBytes = containerLimits(ctx, clientset, namespace, podName, containerName)
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(*sampleUsage)
+		defer ticker.Stop()
+		for {
+			s.sample(ctx, clientset, namespace, podName, containerName)
+			select {
+			case <-s.stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return s
+}
+
+// Stop ends sampling and returns the summary, nil for a nil sampler.
+func (s *usageSampler) Stop() *UsageStats {
+	if s == nil {
+		return nil
+	}
+	close(s.stop)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats.Samples > 0 {
+		s.stats.CPUMillicores.Avg = s.cpuSum / int64(s.stats.Samples)
+		s.stats.MemoryBytes.Avg = s.memorySum / int64(s.stats.Samples)
+		// errors of single samples do not matter when others succeeded.
+		s.stats.Error = ""
+	}
+	return s.stats
+}
+
+func (s *usageSampler) sample(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string, containerName string) {
+	cpu, memory, err := ContainerUsage(ctx, clientset, namespace, podName, containerName)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.stats.Error = err.Error()
+		return
+	}
+	if s.stats.Samples == 0 || cpu < s.stats.CPUMillicores.Min {
+		s.stats.CPUMillicores.Min = cpu
+	}
+	if cpu > s.stats.CPUMillicores.Max {
+		s.stats.CPUMillicores.Max = cpu
+	}
+	if s.stats.Samples == 0 || memory < s.stats.MemoryBytes.Min {
+		s.stats.MemoryBytes.Min = memory
+	}
+	if memory > s.stats.MemoryBytes.Max {
+		s.stats.MemoryBytes.Max = memory
+	}
+	s.cpuSum += cpu
+	s.memorySum += memory
+	s.stats.Samples++
+}
+
+// ContainerUsage returns the cpu (millicores) and memory (bytes) usage of the container, of
+// the whole pod when containerName is empty.
+func ContainerUsage(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string, containerName string) (int64, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	body, err := clientset.CoreV1().RESTClient().Get().AbsPath("/apis/metrics.k8s.io/v1beta1", "namespaces", namespace, "pods", podName).DoRaw(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("read pod metrics error: %v", err)
+	}
+	metrics := &podMetrics{}
+	if err := json.Unmarshal(body, metrics); err != nil {
+		return 0, 0, fmt.Errorf("parse pod metrics error: %v", err)
+	}
+	var cpu, memory int64
+	found := false
+	for _, c := range metrics.Containers {
+		if containerName != "" && c.Name != containerName {
+			continue
+		}
+		found = true
+		cpuUsage := c.Usage[corev1.ResourceCPU]
+		memoryUsage := c.Usage[corev1.ResourceMemory]
+		cpu += cpuUsage.MilliValue()
+		memory += memoryUsage.Value()
+	}
+	if !found {
+		return 0, 0, fmt.Errorf("no metrics of container %q", containerName)
+	}
+	return cpu, memory, nil
+}
+
+// containerLimits returns the cpu and memory limits of the container, summed over the pod when
+// containerName is empty. A container without a limit makes the sum 0.
+func containerLimits(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podName string, containerName string) (int64, int64) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, v1.GetOptions{})
+	if err != nil {
+		return 0, 0
+	}
+	var cpu, memory int64
+	cpuUnlimited, memoryUnlimited := false, false
+	for _, c := range pod.Spec.Containers {
+		if containerName != "" && c.Name != containerName {
+			continue
+		}
+		if q, ok := c.Resources.Limits[corev1.ResourceCPU]; ok {
+			cpu += q.MilliValue()
+		} else {
+			cpuUnlimited = true
+		}
+		if q, ok := c.Resources.Limits[corev1.ResourceMemory]; ok {
+			memory += q.Value()
+		} else {
+			memoryUnlimited = true
+		}
+	}
+	if cpuUnlimited {
+		cpu = 0
+	}
+	if memoryUnlimited {
+		memory = 0
+	}
+	return cpu, memory
+}
+
+// formatBytes renders n with a binary unit, e.g. 512.0Mi.
+func formatBytes(n int64) string {
+	value, unit := float64(n), ""
+	for _, u := range []string{"Ki", "Mi", "Gi", "Ti"} {
+		if value < 1024 {
+			break
+		}
+		value, unit = value/1024, u
+	}
+	if unit == "" {
+		return fmt.Sprintf("%d", n)
+	}
+	return fmt.Sprintf("%.1f%s", value, unit)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+const ErrorTypeInvalidConfig = "invalid-config"
+
+// commandSeparated is set when the command followed --, then none of its arguments is an option.
+var commandSeparated bool
+
+type ConfigProblem struct {
+	Flag    string `json:"flag,omitempty"`
+	Message string `json:"message"`
+	// Conflicts are the other flags Flag can not be combined with.
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// ConfigError is returned when the given options can not describe a valid run.
+type ConfigError struct {
+	Problems []ConfigProblem
+}
+
+func (e *ConfigError) Add(flagName string, format string, args ...interface{}) {
+	e.Problems = append(e.Problems, ConfigProblem{
+		Flag:    flagName,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// AddConflict records that flagName can not be combined with the other flags.
+func (e *ConfigError) AddConflict(flagName string, others []string, format string, args ...interface{}) {
+	names := make([]string, len(others))
+	for i, other := range others {
+		names[i] = "-" + other
+	}
+	e.Problems = append(e.Problems, ConfigProblem{
+		Flag:      flagName,
+		Message:   fmt.Sprintf("conflicts with %s: ", strings.Join(names, ", ")) + fmt.Sprintf(format, args...),
+		Conflicts: others,
+	})
+}
+
+func (e *ConfigError) Error() string {
+	msgs := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		if p.Flag != "" {
+			msgs = append(msgs, fmt.Sprintf("-%s: %s", p.Flag, p.Message))
+		} else {
+			msgs = append(msgs, p.Message)
+		}
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// ErrOrNil returns nil when no problem was recorded.
+func (e *ConfigError) ErrOrNil() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// validateRunMode checks the flags picking how targets are given, -f, -jobs, -profile or
+// -post-deploy, against each other.
+func validateRunMode(cfgErr *ConfigError) {
+	if *configFile != "" && *jobsFile != "" {
+		cfgErr.Add("jobs", "conflicts with -f")
+	}
+	if *postDeploy != "" && (*configFile != "" || *jobsFile != "") {
+		cfgErr.Add("post-deploy", "conflicts with -f and -jobs")
+	}
+	if *action != ActionExec && (*configFile != "" || *jobsFile != "" || *profileName != "") {
+		cfgErr.Add("action", "conflicts with -f, -jobs and -profile, their targets run commands")
+	}
+}
+
+func ValidateFlags(cmd []string) error {
+	cfgErr := validateTargetFlags()
+	validateAction(cfgErr, cmd)
+	validateTCPCheck(cfgErr)
+	validateFetch(cfgErr)
+	if !commandSeparated && len(cmd) > 0 && strings.HasPrefix(cmd[0], "-") {
+		// the options end at the first word that is none, later arguments like ls -l are the command's.
+		cfgErr.Add("", "command %q starts like an option, put the command after --", cmd[0])
+	}
+	if *waitRunningPodTimeout < 0 {
+		cfgErr.Add("wp", "must not be negative")
+	}
+	if *pollInterval <= 0 {
+		cfgErr.Add("poll-interval", "must be positive")
+	}
+	if *pollMaxInterval != 0 && *pollMaxInterval < *pollInterval {
+		cfgErr.Add("poll-max-interval", "must not be less than -poll-interval")
+	}
+	if *allPods && *podName != "" {
+		cfgErr.Add("all", "conflicts with -pn, -all needs a label selector")
+	}
+	if *parallel < 1 {
+		cfgErr.Add("parallel", "must be at least 1")
+	}
+	if *allPods {
+		if *stdoutFile != "" && !strings.Contains(*stdoutFile, "{{pod}}") {
+			cfgErr.Add("stdout-file", "must contain {{pod}} with -all")
+		}
+		if *stderrFile != "" && !strings.Contains(*stderrFile, "{{pod}}") {
+			cfgErr.Add("stderr-file", "must contain {{pod}} with -all")
+		}
+		if *recordCast != "" && !strings.Contains(*recordCast, "{{pod}}") {
+			cfgErr.Add("record-cast", "must contain {{pod}} with -all")
+		}
+	}
+	if *stdoutFile != "" && *stdoutFile == *stderrFile {
+		cfgErr.Add("stderr-file", "must differ from -stdout-file")
+	}
+	validateFanOutThresholds(cfgErr)
+	validateStatefulSet(cfgErr)
+	validateCompare(cfgErr)
+	if *endWebhookPerPod && !*allPods {
+		cfgErr.Add("ew-per-pod", "requires -all")
+	}
+	if *targetOS != OSLinux && *targetOS != OSWindows {
+		cfgErr.Add("os", "must be linux or windows")
+	}
+	if *shell != "" {
+		if _, err := ShellArgs(*shell, ""); err != nil {
+			cfgErr.Add("shell", "%v", err)
+		}
+	}
+	if *outputFormat != "" && *outputFormat != OutputJSON && *outputFormat != OutputText {
+		cfgErr.Add("output", "must be json or text")
+	}
+	validateRedaction(cfgErr)
+	validateDisplayTimezone(cfgErr)
+	validateEndWebhookOutput(cfgErr)
+	validateWorkdir(cfgErr)
+	validateNsenter(cfgErr)
+	validatePostDeploy(cfgErr)
+	validateCIResults(cfgErr)
+	validateGlobalSemaphore(cfgErr)
+	validateCooldown(cfgErr)
+	validatePauseFrom(cfgErr)
+	validateUsageWebhook(cfgErr)
+	validateReattachTimeout(cfgErr)
+	if *expectImage != "" {
+		validateExpectImage(cfgErr, "expect-image", *expectImage)
+	}
+	validateDiagnoseOnFailure(cfgErr)
+	validateRunLabels(cfgErr)
+	if *maxLineBytes != 0 && *maxLineBytes < 4*fragmentOverhead {
+		cfgErr.Add("max-line-bytes", "must be 0 or at least %d", 4*fragmentOverhead)
+	}
+	validateResults(cfgErr)
+	validateSinks(cfgErr)
+	validateAttestation(cfgErr)
+	validateGrafana(cfgErr)
+	if _, ok := meshes[*mesh]; *mesh != "" && !ok {
+		cfgErr.Add("mesh", "must be istio or linkerd")
+	}
+	if *meshQuit && *mesh == "" {
+		cfgErr.Add("mesh-quit", "requires -mesh")
+	}
+	if *execPingPeriod < 0 {
+		cfgErr.Add("exec-ping-period", "must not be negative")
+	}
+	if *execReconnects < 0 {
+		cfgErr.Add("exec-reconnects", "must not be negative")
+	}
+	if *sampleUsage < 0 {
+		cfgErr.Add("sample-usage", "must not be negative")
+	}
+	if *healthHTTP != "" {
+		if _, _, err := parseHealthHTTP(*healthHTTP); err != nil {
+			cfgErr.Add("health-http", "%v", err)
+		}
+	}
+	if *healthWait < 0 {
+		cfgErr.Add("health-wait", "must not be negative")
+	}
+	if *execTimeout < 0 {
+		cfgErr.Add("exec-timeout", "must not be negative")
+	}
+	if *deadlineMargin < 0 {
+		cfgErr.Add("deadline-margin", "must not be negative")
+	}
+	if *proxyURL != "" {
+		if _, err := parseProxyURL(*proxyURL); err != nil {
+			cfgErr.Add("proxy-url", "%v", err)
+		}
+	}
+	if *apiMaxRetries < 0 {
+		cfgErr.Add("api-retries", "must not be negative")
+	}
+	if *historyLimit < 0 {
+		cfgErr.Add("history-limit", "must not be negative")
+	}
+	if *slowThreshold < 0 {
+		cfgErr.Add("slow-threshold", "must not be negative")
+	}
+	if *slowThreshold > 0 && *historyConfigMap == "" {
+		cfgErr.Add("slow-threshold", "requires -history-cm")
+	}
+	if *historyTTL < 0 {
+		cfgErr.Add("history-ttl", "must not be negative")
+	}
+	validateLearnedTimeout(cfgErr)
+	if *timeoutFactor > 0 && *historyConfigMap == "" {
+		cfgErr.Add("timeout-factor", "requires -history-cm")
+	}
+	return cfgErr.ErrOrNil()
+}
+
+// ValidateTargetFlags checks only the flags selecting the target pod.
+func ValidateTargetFlags() error {
+	return validateTargetFlags().ErrOrNil()
+}
+
+func validateTargetFlags() *ConfigError {
+	cfgErr := &ConfigError{}
+	validateTargetSelection(cfgErr)
+	validateWorkload(cfgErr)
+	validateService(cfgErr)
+	validateJobTarget(cfgErr)
+	if *podIP != "" && net.ParseIP(*podIP) == nil {
+		cfgErr.Add("pod-ip", "malformed ip %q", *podIP)
+	}
+	if *labels != "" {
+		if _, err := k8slabels.Parse(*labels); err != nil {
+			cfgErr.Add("l", "malformed label selector: %v", err)
+		}
+	}
+	if len(excludeLabels) > 0 {
+		for _, exclude := range excludeLabels {
+			if _, err := excludeRequirement(exclude); err != nil {
+				cfgErr.Add("exclude-label", "malformed exclusion %q: %v", exclude, err)
+			}
+		}
+	}
+	if *namespace == "" {
+		cfgErr.Add("ns", "must not be empty")
+	} else if err := CheckNamespacePolicy(*namespace); err != nil {
+		cfgErr.Add("ns", "%v", err)
+	}
+	for _, flagName := range []string{"denied-namespaces", "allowed-namespaces"} {
+		for _, pattern := range strings.Split(flag.Lookup(flagName).Value.String(), ",") {
+			if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+				cfgErr.Add(flagName, "malformed pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	if *preferZone != "" && *sameZoneAs != "" {
+		cfgErr.Add("same-zone-as", "conflicts with -prefer-zone")
+	}
+	if *sameZoneAs != "" {
+		if kind, name := splitZoneRef(*sameZoneAs); (kind != "pod" && kind != "node") || name == "" {
+			cfgErr.Add("same-zone-as", "must be pod/<name> or node/<name>")
+		}
+	}
+	validatePodSelection(cfgErr)
+	return cfgErr
+}
+
+// BuildFieldSelector selects pods by ip and node, both optional.
+func BuildFieldSelector(podIP string, nodeName string) string {
+	var selectors []string
+	if podIP != "" {
+		selectors = append(selectors, "status.podIP="+podIP)
+	}
+	if nodeName != "" {
+		selectors = append(selectors, "spec.nodeName="+nodeName)
+	}
+	return strings.Join(selectors, ",")
+}
+
+func joinFieldSelectors(selectors ...string) string {
+	var nonEmpty []string
+	for _, s := range selectors {
+		if s != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	return strings.Join(nonEmpty, ",")
+}
+
+// BuildLabelSelector combines -l with the -exclude-label requirements.
+func BuildLabelSelector() (string, error) {
+	if *labels == "" && len(excludeLabels) == 0 {
+		return "", nil
+	}
+	selector, err := k8slabels.Parse(*labels)
+	if err != nil {
+		return "", err
+	}
+	for _, exclude := range excludeLabels {
+		req, err := excludeRequirement(exclude)
+		if err != nil {
+			return "", err
+		}
+		selector = selector.Add(*req)
+	}
+	return selector.String(), nil
+}
+
+// excludeRequirement translates key=value into key!=value and key into !key.
+func excludeRequirement(exclude string) (*k8slabels.Requirement, error) {
+	if i := strings.Index(exclude, "="); i >= 0 {
+		return k8slabels.NewRequirement(exclude[:i], selection.NotEquals, []string{exclude[i+1:]})
+	}
+	return k8slabels.NewRequirement(exclude, selection.DoesNotExist, nil)
+}
+
+// The pods of a run come from exactly one source, in this order: -workload acts on a
+// workload and picks no pod, -pn names the pod, -post-deploy, -job, -service and
+// -statefulset take the pods of that object. The filters narrow the pods of the source, or without one pick among
+// the pods of -ns; they do not apply to -workload and -pn.
+var (
+	targetSources = []string{"workload", "pn", "post-deploy", "job", "service", "statefulset"}
+	targetFilters = []string{"l", "pod-ip", "node-name", "exclude-label"}
+)
+
+// validatePodSelection checks -wait-for, -where and -rank-by, which CompilePodSelection parses.
+func validatePodSelection(cfgErr *ConfigError) {
+	if _, err := ParsePodExpr(*waitFor); err != nil {
+		cfgErr.Add("wait-for", "malformed expression: %v", err)
+	}
+	if *where != "" {
+		if _, err := ParsePodExpr(*where); err != nil {
+			cfgErr.Add("where", "malformed expression: %v", err)
+		}
+	}
+	if *rankBy != "" {
+		if _, err := ParsePodRank(*rankBy); err != nil {
+			cfgErr.Add("rank-by", "malformed field path: %v", err)
+		}
+		if *preferIdle {
+			cfgErr.Add("prefer-idle", "conflicts with -rank-by")
+		}
+	}
+}
+
+// validateTargetSelection rejects ambiguous combinations of the source and filter flags
+// rather than letting one of them win.
+func validateTargetSelection(cfgErr *ConfigError) {
+	sources, filters := setFlags(targetSources), setFlags(targetFilters)
+	switch {
+	case len(sources) == 0 && len(filters) == 0:
+		cfgErr.Add("", "one of -pn, -l, -pod-ip, -node-name, -service, -statefulset, -job, -post-deploy or -workload is required")
+	case len(sources) > 1:
+		cfgErr.AddConflict(sources[0], sources[1:], "each of -workload, -pn, -post-deploy, -job, -service and -statefulset picks the target on its own, use one")
+	case len(sources) == 1 && sources[0] == "workload" && len(filters) > 0:
+		cfgErr.AddConflict("workload", filters, "-action %s applies to the workload, not to pods", *action)
+	case len(sources) == 1 && sources[0] == "pn" && len(filters) > 0:
+		cfgErr.AddConflict("pn", filters, "-pn names the pod, filters only narrow the pods of -post-deploy, -job, -service, -statefulset or -ns")
+	}
+}
+
+// setFlags returns the flags of names that are not empty.
+func setFlags(names []string) []string {
+	var set []string
+	for _, name := range names {
+		if flag.Lookup(name).Value.String() != "" {
+			set = append(set, name)
+		}
+	}
+	return set
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	valuesFiles stringsFlag
+	setValues   stringsFlag
+)
+
+func init() {
+	flag.Var(&valuesFiles, "values", "yaml file of values for the -f config template, later files override earlier ones, repeatable")
+	flag.Var(&setValues, "set", "key.path=value overriding -values, repeatable")
+}
+
+// configTemplateData is what the -f config template is executed with. The chain fields
+// render to themselves so the command templates are left for the chained runs, which is why
+// checkChainFields only allows them as a bare {{.Stdout}}, {{.Pod}} or {{.Namespace}}.
+type configTemplateData struct {
+	Values    map[string]interface{}
+	Namespace string
+	Pod       string
+	Stdout    string
+}
+
+// LoadValues merges the -values files in order, then applies -set.
+func LoadValues() (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, path := range valuesFiles {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		overlay := map[string]interface{}{}
+		if err := yaml.Unmarshal(b, &overlay); err != nil {
+			return nil, fmt.Errorf("parse values %s error: %v", path, err)
+		}
+		mergeValues(values, overlay)
+	}
+	for _, set := range setValues {
+		if err := setValue(values, set); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// mergeValues deep merges overlay into values, overlay wins.
+func mergeValues(values map[string]interface{}, overlay map[string]interface{}) {
+	for k, v := range overlay {
+		if src, ok := v.(map[string]interface{}); ok {
+			if dst, ok := values[k].(map[string]interface{}); ok {
+				mergeValues(dst, src)
+				continue
+			}
+		}
+		values[k] = v
+	}
+}
+
+func setValue(values map[string]interface{}, set string) error {
+	i := strings.Index(set, "=")
+	if i <= 0 {
+		return fmt.Errorf("malformed -set %q, want key=value", set)
+	}
+	path, err := ParseFieldPath(set[:i])
+	if err != nil {
+		return fmt.Errorf("malformed -set %q: %v", set, err)
+	}
+	cur := values
+	for _, segment := range path[:len(path)-1] {
+		next, ok := cur[segment].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			cur[segment] = next
+		}
+		cur = next
+	}
+	cur[path[len(path)-1]] = set[i+1:]
+	return nil
+}
+
+// renderConfig executes the config file as a template over the values.
+func renderConfig(b []byte, values map[string]interface{}) ([]byte, error) {
+	tmpl, err := template.New("config").Option("missingkey=error").Funcs(alertPlaceholderFuncs).Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if err := checkChainFields(t.Tree.Root); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, &configTemplateData{
+		Values:    values,
+		Namespace: "{{.Namespace}}",
+		Pod:       "{{.Pod}}",
+		Stdout:    "{{.Stdout}}",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// chainFields are the fields of configTemplateData left for the chained runs.
+var chainFields = map[string]bool{"Namespace": true, "Pod": true, "Stdout": true}
+
+// checkChainFields rejects uses of the chain fields other than a bare {{.Field}}, they
+// would be evaluated over the placeholder instead of the previous run.
+func checkChainFields(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkChainFields(child); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) == 0 && len(n.Pipe.Cmds) == 1 && len(n.Pipe.Cmds[0].Args) == 1 {
+			if field, ok := n.Pipe.Cmds[0].Args[0].(*parse.FieldNode); ok && len(field.Ident) == 1 {
+				return nil
+			}
+		}
+		return checkChainPipe(n.Pipe, n)
+	case *parse.IfNode:
+		return checkChainBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		return checkChainBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return checkChainBranch(&n.BranchNode)
+	case *parse.TemplateNode:
+		if n.Pipe != nil {
+			return checkChainPipe(n.Pipe, n)
+		}
+	}
+	return nil
+}
+
+func checkChainBranch(n *parse.BranchNode) error {
+	if err := checkChainPipe(n.Pipe, n); err != nil {
+		return err
+	}
+	if err := checkChainFields(n.List); err != nil {
+		return err
+	}
+	return checkChainFields(n.ElseList)
+}
+
+// checkChainPipe fails if the pipeline of node reads a chain field.
+func checkChainPipe(pipe *parse.PipeNode, node parse.Node) error {
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			var ident []string
+			switch a := arg.(type) {
+			case *parse.FieldNode:
+				ident = a.Ident
+			case *parse.VariableNode:
+				if len(a.Ident) > 1 && a.Ident[0] == "$" {
+					ident = a.Ident[1:]
+				}
+			case *parse.PipeNode:
+				if err := checkChainPipe(a, node); err != nil {
+					return err
+				}
+			}
+			if len(ident) > 0 && chainFields[ident[0]] {
+				return fmt.Errorf("config template: %s uses the chain field .%s, which is only left for the chained run as {{.%s}}", node, ident[0], ident[0])
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var verifyCmd = flag.String("verify-cmd", "", "shell command run in the same container after the command succeeded, its failure fails the run, e.g. test -s /backups/latest.sql")
+
+type VerifyResult struct {
+	Command string `json:"command"`
+	Stdout  string `json:"stdout"`
+	Stderr  string `json:"stderr"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RunVerify runs -verify-cmd through the default shell of the target os.
+func RunVerify(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, namespace string, podName string, containerName string) *VerifyResult {
+	args, _ := ShellArgs(DefaultShell(), *verifyCmd)
+	stdout, stderr, err := ExecInPod(ctx, clientset, config, namespace, podName, containerName, args)
+	result := &VerifyResult{
+		Command: *verifyCmd,
+		Stdout:  stdout,
+		Stderr:  stderr,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
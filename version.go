@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// set at build time with -ldflags "-X main.version=v1.2.3 -X main.commit=abc123".
+var (
+	version = "dev"
+	commit  = ""
+)
+
+var (
+	checkUpdate     = flag.Bool("check-update", false, "version: compare with the latest release of -release-manifest")
+	releaseManifest = flag.String("release-manifest", "", "url of a json release manifest {\"version\": \"v1.2.3\", \"url\": \"...\"}")
+)
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+func GetBuildInfo() *BuildInfo {
+	return &BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+type Release struct {
+	Version string `json:"version"`
+	URL     string `json:"url,omitempty"`
+}
+
+// RunVersion prints the build info, with -check-update also the latest release.
+func RunVersion() {
+	reply := map[string]interface{}{
+		"build": GetBuildInfo(),
+	}
+	if *checkUpdate {
+		if *releaseManifest == "" {
+			cfgErr := &ConfigError{}
+			cfgErr.Add("release-manifest", "is required with -check-update")
+			SendError(&Response{
+				Error: cfgErr,
+			})
+		}
+		latest, err := FetchRelease(*releaseManifest)
+		if err != nil {
+			SendError(&Response{
+				Error: fmt.Errorf("check update error: %v", err),
+			})
+		}
+		reply["latest"] = latest
+		reply["updateAvailable"] = compareVersions(latest.Version, version) > 0
+	}
+	b, _ := json.Marshal(reply)
+	fmt.Println(string(b))
+}
+
+func FetchRelease(url string) (*Release, error) {
+	resp, err := webhookClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("release manifest responded %s", resp.Status)
+	}
+	release := &Release{}
+	if err := json.NewDecoder(resp.Body).Decode(release); err != nil {
+		return nil, fmt.Errorf("parse release manifest error: %v", err)
+	}
+	if release.Version == "" {
+		return nil, fmt.Errorf("release manifest has no version")
+	}
+	return release, nil
+}
+
+// compareVersions compares dotted numeric versions like v1.10.2, a dev build is older than any release.
+func compareVersions(a string, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(strings.SplitN(as[i], "-", 2)[0])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(strings.SplitN(bs[i], "-", 2)[0])
+		}
+		if x != y {
+			return compareFloats(float64(x), float64(y))
+		}
+	}
+	return 0
+}
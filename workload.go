@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	workload     = flag.String("workload", "", "deployment/name, statefulset/name or daemonset/name in -ns the workload actions apply to")
+	replicas     = flag.Int("replicas", -1, "the replicas -action scale sets")
+	waitWorkload = flag.Bool("wait-rollout", false, "after -action rollout-restart or scale, wait up to -rollout-timeout until every pod of -workload runs the latest spec and is available")
+)
+
+// isWorkloadAction reports whether -action applies to -workload rather than to a pod.
+func isWorkloadAction() bool {
+	return *action == ActionRolloutRestart || *action == ActionScale
+}
+
+func validateWorkload(cfgErr *ConfigError) {
+	if *action != ActionScale && *replicas != -1 {
+		cfgErr.Add("replicas", "requires -action scale")
+	}
+	if !isWorkloadAction() {
+		if *workload != "" {
+			cfgErr.Add("workload", "requires -action rollout-restart or scale")
+		}
+		if *waitWorkload {
+			cfgErr.Add("wait-rollout", "requires -action rollout-restart or scale")
+		}
+		return
+	}
+	kind, name := splitWorkloadRef(*workload)
+	if name == "" || (kind != "deployment" && kind != "statefulset" && kind != "daemonset") {
+		cfgErr.Add("workload", "must be deployment/<name>, statefulset/<name> or daemonset/<name>")
+	}
+	if *action == ActionScale {
+		if kind == "daemonset" {
+			cfgErr.Add("workload", "a daemonset can not be scaled")
+		}
+		if *replicas < 0 {
+			cfgErr.Add("replicas", "is required and must not be negative")
+		}
+	}
+	if *waitWorkload && *rolloutTimeout <= 0 {
+		cfgErr.Add("rollout-timeout", "must be positive")
+	}
+	if *allPods {
+		cfgErr.Add("workload", "conflicts with -all, -action %s applies to the workload", *action)
+	}
+}
+
+// RunWorkloadAction does -action to -workload, holding a -global-semaphore slot meanwhile.
+func RunWorkloadAction(ctx context.Context, clientset *kubernetes.Clientset) *Response {
+	target := Target{
+		Name:      *workload,
+		Namespace: *namespace,
+	}
+	kind, name := splitWorkloadRef(*workload)
+	start := time.Now()
+	EmitEvent(EventExecStarted, map[string]interface{}{
+		"target":    target.Name,
+		"namespace": target.Namespace,
+		"action":    *action,
+	})
+	slot, err := AcquireSemaphore(ctx, clientset)
+	if err != nil {
+		return &Response{
+			Target: target.Name,
+			Error:  err,
+		}
+	}
+	var out string
+	switch *action {
+	case ActionRolloutRestart:
+		out, err = RolloutRestart(ctx, clientset, target.Namespace, kind, name)
+	case ActionScale:
+		out, err = Scale(ctx, clientset, target.Namespace, kind, name, int32(*replicas))
+	}
+	if err == nil && *waitWorkload {
+		_, err = WaitRollout(ctx, clientset, target.Namespace, kind, name, *rolloutTimeout)
+	}
+	slot.Release()
+	resp := &Response{
+		Target:    target.Name,
+		Stdout:    out,
+		Error:     err,
+		Cancelled: ctx.Err() != nil,
+		Duration:  time.Since(start).String(),
+	}
+	if err := RecordResult(&target, start, resp); err != nil {
+		fmt.Fprintf(os.Stderr, "record result error: %v\n", err)
+	}
+	return resp
+}
+
+// Scale sets the replicas of the deployment or statefulset and reports the previous count, so
+// a later run can scale back.
+func Scale(ctx context.Context, clientset *kubernetes.Clientset, namespace string, kind string, name string, replicas int32) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+	patch := []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas))
+	var previous *int32
+	var err error
+	if kind == "deployment" {
+		var d *appsv1.Deployment
+		if d, err = clientset.AppsV1().Deployments(namespace).Get(ctx, name, v1.GetOptions{}); err == nil {
+			previous = d.Spec.Replicas
+			_, err = clientset.AppsV1().Deployments(namespace).Patch(ctx, name, types.MergePatchType, patch, v1.PatchOptions{})
+		}
+	} else {
+		var s *appsv1.StatefulSet
+		if s, err = clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, v1.GetOptions{}); err == nil {
+			previous = s.Spec.Replicas
+			_, err = clientset.AppsV1().StatefulSets(namespace).Patch(ctx, name, types.MergePatchType, patch, v1.PatchOptions{})
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("scale %s/%s error: %v", kind, name, err)
+	}
+	from := int32(1)
+	if previous != nil {
+		from = *previous
+	}
+	return fmt.Sprintf("%s/%s scaled from %d to %d replicas", kind, name, from, replicas), nil
+}
+
+// RolloutRestart sets the restartedAt annotation of the pod template like kubectl rollout
+// restart, the controller then replaces the pods following its update strategy.
+func RolloutRestart(ctx context.Context, clientset *kubernetes.Clientset, namespace string, kind string, name string) (string, error) {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						"kubectl.kubernetes.io/restartedAt": time.Now().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+	switch kind {
+	case "deployment":
+		_, err = clientset.AppsV1().Deployments(namespace).Patch(ctx, name, types.MergePatchType, patch, v1.PatchOptions{})
+	case "statefulset":
+		_, err = clientset.AppsV1().StatefulSets(namespace).Patch(ctx, name, types.MergePatchType, patch, v1.PatchOptions{})
+	default:
+		_, err = clientset.AppsV1().DaemonSets(namespace).Patch(ctx, name, types.MergePatchType, patch, v1.PatchOptions{})
+	}
+	if err != nil {
+		return "", fmt.Errorf("restart %s/%s error: %v", kind, name, err)
+	}
+	return fmt.Sprintf("%s/%s restarted", kind, name), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	preferZone = flag.String("prefer-zone", "", "pick an eligible pod on a node in this zone when there is one")
+	sameZoneAs = flag.String("same-zone-as", "", "pod/<name> in -ns or node/<name>, pick an eligible pod in the same zone when there is one")
+
+	// nodeZones caches the zone of every node looked up, -jobs look up pods concurrently.
+	nodeZones   = map[string]string{}
+	nodeZonesMu sync.Mutex
+)
+
+// PreferredZone returns the zone of -prefer-zone or -same-zone-as, "" when neither is set.
+func PreferredZone(ctx context.Context, clientset *kubernetes.Clientset, namespace string) (string, error) {
+	if *preferZone != "" {
+		return *preferZone, nil
+	}
+	if *sameZoneAs == "" {
+		return "", nil
+	}
+	kind, name := splitZoneRef(*sameZoneAs)
+	if kind == "pod" {
+		var pod *corev1.Pod
+		err := RetryAPI(ctx, func() (err error) {
+			pod, err = clientset.CoreV1().Pods(namespace).Get(ctx, name, v1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		if pod.Spec.NodeName == "" {
+			return "", fmt.Errorf("pod %s is not scheduled", name)
+		}
+		name = pod.Spec.NodeName
+	}
+	zone, err := NodeZone(ctx, clientset, name)
+	if err != nil {
+		return "", err
+	}
+	if zone == "" {
+		return "", fmt.Errorf("node %s has no %s label", name, corev1.LabelTopologyZone)
+	}
+	return zone, nil
+}
+
+func splitZoneRef(ref string) (string, string) {
+	if i := strings.Index(ref, "/"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return "", ref
+}
+
+// NodeZone returns the topology.kubernetes.io/zone label of the node.
+func NodeZone(ctx context.Context, clientset *kubernetes.Clientset, nodeName string) (string, error) {
+	nodeZonesMu.Lock()
+	zone, ok := nodeZones[nodeName]
+	nodeZonesMu.Unlock()
+	if ok {
+		return zone, nil
+	}
+	var node *corev1.Node
+	err := RetryAPI(ctx, func() (err error) {
+		ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+		defer cancel()
+		node, err = clientset.CoreV1().Nodes().Get(ctx, nodeName, v1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	zone = node.Labels[corev1.LabelTopologyZone]
+	nodeZonesMu.Lock()
+	nodeZones[nodeName] = zone
+	nodeZonesMu.Unlock()
+	return zone, nil
+}
+
+// FilterZone returns the eligible pods in zone, or all pods when none of them is.
+func FilterZone(ctx context.Context, clientset *kubernetes.Clientset, pods []corev1.Pod, zone string) []corev1.Pod {
+	var inZone []corev1.Pod
+	for i := range pods {
+		if !IsEligiblePod(&pods[i]) || pods[i].Spec.NodeName == "" {
+			continue
+		}
+		podZone, err := NodeZone(ctx, clientset, pods[i].Spec.NodeName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read node zone error: %v\n", err)
+			return pods
+		}
+		if podZone == zone {
+			inZone = append(inZone, pods[i])
+		}
+	}
+	if len(inZone) == 0 {
+		return pods
+	}
+	return inZone
+}